@@ -0,0 +1,291 @@
+package httputils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+var ErrResponseCommitted = errors.New("response already committed")
+
+// BufferedResponseWriter buffers the header and the body up to Threshold
+// bytes so that a handler can Reset the response and write an error instead.
+// Once the body exceeds Threshold (or Flush is called) the buffer is written
+// to the underlying writer and the rest of the response is streamed. Commit
+// must be called after the handler returns; if the whole response fits in the
+// buffer, Content-Length is set automatically. BufferedHandler takes care of
+// Commit and Reset.
+type BufferedResponseWriter struct {
+	http.ResponseWriter
+	passThrough
+	Threshold      int
+	StatusCode     int
+	ResponseLength int64
+	// Committed is true once the header was written to the underlying writer.
+	Committed bool
+
+	header        http.Header
+	initialHeader http.Header
+	buf           bytes.Buffer
+	wroteHeader   bool
+}
+
+// NewBufferedResponseWriter returns a writer buffering up to threshold body
+// bytes. Pass Wrap() to handlers so that they see the same optional interfaces
+// as on w.
+func NewBufferedResponseWriter(w http.ResponseWriter, threshold int) *BufferedResponseWriter {
+	initialHeader := w.Header().Clone()
+	if initialHeader == nil {
+		initialHeader = http.Header{}
+	}
+
+	bw := &BufferedResponseWriter{
+		ResponseWriter: w,
+		Threshold:      threshold,
+		StatusCode:     http.StatusOK,
+		ResponseLength: 0,
+		Committed:      false,
+
+		header:        initialHeader.Clone(),
+		initialHeader: initialHeader,
+	}
+	bw.passThrough = passThrough{&bw.ResponseWriter}
+
+	return bw
+}
+
+func (w *BufferedResponseWriter) Header() http.Header {
+	if w.Committed {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+// WriteHeader buffers the status code. As with http.ResponseWriter only the
+// first final status code counts (until Reset). Informational responses are
+// written to the underlying writer right away.
+func (w *BufferedResponseWriter) WriteHeader(statusCode int) {
+	if w.Committed {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		// informational responses can be followed by the final response
+		w.copyHeader()
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.StatusCode = statusCode
+}
+
+func (w *BufferedResponseWriter) Write(b []byte) (int, error) {
+	// an implicit WriteHeader(http.StatusOK)
+	w.wroteHeader = true
+
+	if !w.Committed && w.buf.Len()+len(b) > w.Threshold {
+		if err := w.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.Committed {
+		n, err := w.ResponseWriter.Write(b)
+		w.ResponseLength += int64(n)
+		return n, err
+	}
+
+	n, _ := w.buf.Write(b)
+	w.ResponseLength += int64(n)
+	return n, nil
+}
+
+// Buffered returns the number of buffered body bytes.
+func (w *BufferedResponseWriter) Buffered() int {
+	return w.buf.Len()
+}
+
+// Reset discards the buffered header, status code and body. It returns
+// ErrResponseCommitted if the response was already written to the underlying
+// writer.
+func (w *BufferedResponseWriter) Reset() error {
+	if w.Committed {
+		return ErrResponseCommitted
+	}
+
+	w.header = w.initialHeader.Clone()
+	w.StatusCode = http.StatusOK
+	w.ResponseLength = 0
+	w.buf.Reset()
+	w.wroteHeader = false
+
+	return nil
+}
+
+// Flush switches to streaming mode and flushes the underlying writer.
+func (w *BufferedResponseWriter) Flush() {
+	_ = w.FlushError()
+}
+
+func (w *BufferedResponseWriter) FlushError() error {
+	if err := w.spill(); err != nil {
+		return err
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack discards the buffered response and hijacks the underlying
+// connection.
+func (w *BufferedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, ErrResponseNotHijacker
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return conn, rw, err
+	}
+
+	// nothing must be written to the hijacked connection on Commit
+	w.Committed = true
+	w.buf.Reset()
+
+	return conn, rw, nil
+}
+
+// ReadFrom buffers src up to Threshold bytes. Once the response is committed
+// the rest of src is passed to the underlying io.ReaderFrom.
+func (w *BufferedResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	var n int64
+
+	if !w.Committed {
+		// one byte past the threshold spills the buffer
+		var err error
+		n, err = io.Copy(writerOnly{w}, io.LimitReader(src, int64(w.Threshold-w.buf.Len()+1)))
+		if err != nil || !w.Committed {
+			return n, err
+		}
+	}
+
+	readerFrom, ok := w.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		m, err := io.Copy(writerOnly{w}, src)
+		return n + m, err
+	}
+
+	m, err := readerFrom.ReadFrom(src)
+	w.ResponseLength += m
+	return n + m, err
+}
+
+// Wrap returns a http.ResponseWriter backed by w which implements exactly the
+// optional interfaces (http.Flusher, http.Hijacker, io.ReaderFrom,
+// http.Pusher, http.CloseNotifier) implemented by the underlying writer.
+func (w *BufferedResponseWriter) Wrap() http.ResponseWriter {
+	return wrapResponseWriter(w, w.ResponseWriter)
+}
+
+// Commit writes the buffered response to the underlying writer. If the
+// response was not spilled, Content-Length is set to the buffered length.
+func (w *BufferedResponseWriter) Commit() error {
+	if w.Committed {
+		return nil
+	}
+
+	if bodyAllowedForStatus(w.StatusCode) && w.header.Get("Content-Length") == "" {
+		w.header.Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	}
+
+	return w.spill()
+}
+
+// spill writes the buffered header and body to the underlying writer.
+func (w *BufferedResponseWriter) spill() error {
+	if w.Committed {
+		return nil
+	}
+	w.Committed = true
+
+	w.copyHeader()
+	w.ResponseWriter.WriteHeader(w.StatusCode)
+
+	if w.buf.Len() == 0 {
+		return nil
+	}
+
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// BufferedHandler calls fn with a BufferedResponseWriter of threshold bytes and
+// commits the response after fn returns. If fn returns an error or panics
+// before the response was committed, the buffered response is replaced with a
+// problem response (see ResponseError). Panics are re-raised after the
+// problem response is sent. Errors after the response was committed cannot be
+// reported to the client and are ignored.
+func BufferedHandler(threshold int, fn func(w http.ResponseWriter, r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bw := NewBufferedResponseWriter(w, threshold)
+
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v != http.ErrAbortHandler && bw.Reset() == nil {
+				_ = ResponseError(bw, r, http.StatusInternalServerError, fmt.Errorf("panic: %v", v))
+				if err := bw.Commit(); err == nil {
+					// the server does not flush the response of a panicking
+					// handler
+					_ = http.NewResponseController(w).Flush()
+				}
+			}
+			panic(v)
+		}()
+
+		if err := fn(bw.Wrap(), r); err != nil && bw.Reset() == nil {
+			_ = ResponseError(bw, r, ErrorStatusCode(err), err)
+		}
+
+		_ = bw.Commit()
+	})
+}
+
+// copyHeader replaces the header of the underlying writer with the buffered
+// header.
+func (w *BufferedResponseWriter) copyHeader() {
+	h := w.ResponseWriter.Header()
+	for key := range h {
+		if _, ok := w.header[key]; !ok {
+			delete(h, key)
+		}
+	}
+	for key, values := range w.header {
+		h[key] = values
+	}
+}
+
+func bodyAllowedForStatus(statusCode int) bool {
+	switch {
+	case statusCode >= 100 && statusCode <= 199:
+		return false
+	case statusCode == http.StatusNoContent:
+		return false
+	case statusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+var _ http.ResponseWriter = (*BufferedResponseWriter)(nil)
+var _ optionalResponseWriter = (*BufferedResponseWriter)(nil)
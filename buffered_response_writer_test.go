@@ -0,0 +1,273 @@
+package httputils_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+// statusCodesRecorder records every WriteHeader call.
+type statusCodesRecorder struct {
+	*httptest.ResponseRecorder
+	codes []int
+}
+
+func (r *statusCodesRecorder) WriteHeader(statusCode int) {
+	r.codes = append(r.codes, statusCode)
+	r.ResponseRecorder.WriteHeader(statusCode)
+}
+
+var _ = Describe("BufferedResponseWriter", func() {
+	It("should buffer the response and set Content-Length on Commit", func() {
+		rec := httptest.NewRecorder()
+		w := NewBufferedResponseWriter(rec, 1024)
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		_, err := w.Write([]byte("hello"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(rec.Body.Len()).To(Equal(0))
+		Expect(rec.Header().Get("Content-Type")).To(BeEmpty())
+		Expect(w.Buffered()).To(Equal(5))
+
+		Expect(w.Commit()).To(Succeed())
+
+		Expect(rec.Code).To(Equal(http.StatusCreated))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("text/plain"))
+		Expect(rec.Header().Get("Content-Length")).To(Equal("5"))
+		Expect(rec.Body.String()).To(Equal("hello"))
+		Expect(w.ResponseLength).To(Equal(int64(5)))
+	})
+
+	It("should discard the buffered response on Reset", func() {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("X-Request-Id", "1")
+		w := NewBufferedResponseWriter(rec, 1024)
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Request-Id", "changed")
+		_, _ = w.Write([]byte("partial"))
+
+		Expect(w.Reset()).To(Succeed())
+
+		err := ResponseJSON(w, httptest.NewRequest("GET", "/", nil), http.StatusInternalServerError, map[string]string{"error": "failed"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Commit()).To(Succeed())
+
+		Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json; charset=utf-8"))
+		Expect(rec.Header().Get("X-Request-Id")).To(Equal("1"))
+		Expect(rec.Body.String()).To(Equal(`{"error":"failed"}`))
+	})
+
+	It("should spill to streaming mode past the threshold", func() {
+		rec := httptest.NewRecorder()
+		w := NewBufferedResponseWriter(rec, 4)
+
+		_, _ = w.Write([]byte("abc"))
+		Expect(rec.Body.Len()).To(Equal(0))
+
+		_, _ = w.Write([]byte("def"))
+		Expect(w.Committed).To(BeTrue())
+		Expect(rec.Body.String()).To(Equal("abcdef"))
+
+		Expect(w.Reset()).To(Equal(ErrResponseCommitted))
+
+		_, _ = w.Write([]byte(strings.Repeat("x", 10)))
+		Expect(w.Commit()).To(Succeed())
+
+		Expect(rec.Header().Get("Content-Length")).To(BeEmpty())
+		Expect(rec.Body.Len()).To(Equal(16))
+		Expect(w.ResponseLength).To(Equal(int64(16)))
+	})
+
+	It("should spill on Flush", func() {
+		rec := httptest.NewRecorder()
+		w := NewBufferedResponseWriter(rec, 1024)
+
+		_, _ = w.Write([]byte("event"))
+		w.Flush()
+
+		Expect(w.Committed).To(BeTrue())
+		Expect(rec.Flushed).To(BeTrue())
+		Expect(rec.Body.String()).To(Equal("event"))
+	})
+
+	It("should not set Content-Length for responses without a body", func() {
+		rec := httptest.NewRecorder()
+		w := NewBufferedResponseWriter(rec, 1024)
+
+		w.WriteHeader(http.StatusNoContent)
+		Expect(w.Commit()).To(Succeed())
+
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+		Expect(rec.Header().Get("Content-Length")).To(BeEmpty())
+	})
+
+	It("should keep the first status code until Reset", func() {
+		rec := httptest.NewRecorder()
+		w := NewBufferedResponseWriter(rec, 1024)
+
+		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(http.StatusInternalServerError)
+		Expect(w.StatusCode).To(Equal(http.StatusOK))
+
+		Expect(w.Reset()).To(Succeed())
+		w.WriteHeader(http.StatusNotFound)
+		Expect(w.Commit()).To(Succeed())
+
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should ignore WriteHeader after Write", func() {
+		rec := httptest.NewRecorder()
+		w := NewBufferedResponseWriter(rec, 1024)
+
+		_, _ = w.Write([]byte("hello"))
+		w.WriteHeader(http.StatusInternalServerError)
+		Expect(w.Commit()).To(Succeed())
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should write informational responses right away", func() {
+		rec := &statusCodesRecorder{ResponseRecorder: httptest.NewRecorder()}
+		w := NewBufferedResponseWriter(rec, 1024)
+
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusCreated)
+		Expect(w.StatusCode).To(Equal(http.StatusCreated))
+		Expect(w.Commit()).To(Succeed())
+
+		Expect(rec.codes).To(Equal([]int{http.StatusEarlyHints, http.StatusCreated}))
+		Expect(rec.Header().Get("Link")).To(Equal("</style.css>; rel=preload"))
+	})
+
+	It("should discard the buffered response on Hijack", func() {
+		fw := newFullResponseWriter()
+		w := NewBufferedResponseWriter(fw, 1024)
+
+		_, _ = w.Write([]byte("hello"))
+
+		hijacker, ok := w.Wrap().(http.Hijacker)
+		Expect(ok).To(BeTrue())
+		conn, _, err := hijacker.Hijack()
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		Expect(w.Commit()).To(Succeed())
+		Expect(fw.Body.Len()).To(Equal(0))
+	})
+
+	It("should use the underlying ReadFrom once committed", func() {
+		fw := newFullResponseWriter()
+		w := NewBufferedResponseWriter(fw, 4)
+
+		readerFrom, ok := w.Wrap().(io.ReaderFrom)
+		Expect(ok).To(BeTrue())
+
+		n, err := readerFrom.ReadFrom(struct{ io.Reader }{strings.NewReader("hello world")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(int64(11)))
+
+		Expect(w.Committed).To(BeTrue())
+		Expect(fw.readFromCalled).To(BeTrue())
+		Expect(fw.Body.String()).To(Equal("hello world"))
+		Expect(w.ResponseLength).To(Equal(int64(11)))
+	})
+
+	It("should buffer ReadFrom below the threshold", func() {
+		fw := newFullResponseWriter()
+		w := NewBufferedResponseWriter(fw, 1024)
+
+		n, err := w.ReadFrom(struct{ io.Reader }{strings.NewReader("hello")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(int64(5)))
+
+		Expect(w.Committed).To(BeFalse())
+		Expect(w.Buffered()).To(Equal(5))
+		Expect(fw.readFromCalled).To(BeFalse())
+	})
+})
+
+var _ = Describe("BufferedHandler", func() {
+	It("should commit the response", func() {
+		rec := httptest.NewRecorder()
+
+		BufferedHandler(1024, func(w http.ResponseWriter, r *http.Request) error {
+			_, _ = w.Write([]byte("hello"))
+			return nil
+		}).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Length")).To(Equal("5"))
+		Expect(rec.Body.String()).To(Equal("hello"))
+	})
+
+	It("should replace the buffered response with an error", func() {
+		rec := httptest.NewRecorder()
+
+		BufferedHandler(1024, func(w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("X-Partial", "1")
+			_, _ = w.Write([]byte("partial"))
+			return NewHTTPError(http.StatusConflict, errors.New("conflict"))
+		}).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusConflict))
+		Expect(rec.Header().Get("X-Partial")).To(BeEmpty())
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/problem+json"))
+		Expect(rec.Body.String()).NotTo(ContainSubstring("partial"))
+	})
+
+	It("should keep a committed response on error", func() {
+		rec := httptest.NewRecorder()
+
+		BufferedHandler(4, func(w http.ResponseWriter, r *http.Request) error {
+			_, _ = w.Write([]byte("hello"))
+			return errors.New("failed")
+		}).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(Equal("hello"))
+	})
+
+	It("should send an error response and re-panic on panic", func() {
+		rec := httptest.NewRecorder()
+
+		handler := BufferedHandler(1024, func(w http.ResponseWriter, r *http.Request) error {
+			_, _ = w.Write([]byte("partial"))
+			panic("boom")
+		})
+
+		Expect(func() {
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		}).To(PanicWith("boom"))
+
+		Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/problem+json"))
+		Expect(rec.Body.String()).NotTo(ContainSubstring("partial"))
+		Expect(rec.Body.String()).NotTo(ContainSubstring("boom"))
+	})
+
+	It("should preserve the optional interfaces of the response writer", func() {
+		var isHijacker, isReaderFrom bool
+
+		BufferedHandler(1024, func(w http.ResponseWriter, r *http.Request) error {
+			_, isHijacker = w.(http.Hijacker)
+			_, isReaderFrom = w.(io.ReaderFrom)
+			return nil
+		}).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		Expect(isHijacker).To(BeFalse())
+		Expect(isReaderFrom).To(BeFalse())
+	})
+})
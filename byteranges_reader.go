@@ -0,0 +1,270 @@
+package httputils
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+
+	"github.com/koofr/go-ioutils"
+)
+
+// ByteRangesReader reads the parts of a response to a range request. It
+// handles multipart/byteranges responses, single range 206 responses and 200
+// responses of servers that ignore the Range header, in which case the
+// requested spans are cut from the full body.
+//
+// Every part's Content-Range must match one of the requested spans. Servers
+// may return the parts in any order but must not coalesce them. A requested
+// span with a negative End extends to the end of the file (see FormatRange).
+type ByteRangesReader struct {
+	// Size is the complete length of the resource or -1 if unknown. For
+	// multipart responses it is known after the first call to Next.
+	Size int64
+
+	body     io.ReadCloser
+	spans    []ioutils.FileSpan
+	returned []bool
+
+	// multipart/byteranges responses
+	multipartReader *multipart.Reader
+
+	// single range 206 responses
+	single     bool
+	singleSpan ioutils.FileSpan
+
+	// 200 responses
+	fullSpans   []ioutils.FileSpan
+	fullOffset  int64
+	current     *spanReader
+	currentSpan ioutils.FileSpan
+}
+
+// NewByteRangesReader returns a reader for resp, which must be the response to
+// a request for spans. It returns ErrUnexpectedStatus for responses other than
+// 200 and 206.
+func NewByteRangesReader(resp *http.Response, spans []ioutils.FileSpan) (*ByteRangesReader, error) {
+	r := &ByteRangesReader{
+		Size: -1,
+
+		body:     resp.Body,
+		spans:    spans,
+		returned: make([]bool, len(spans)),
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		r.Size = resp.ContentLength
+		if err := r.initFull(); err != nil {
+			return nil, err
+		}
+		return r, nil
+
+	case http.StatusPartialContent:
+		mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if mediaType == "multipart/byteranges" {
+			if params["boundary"] == "" {
+				return nil, errors.New("multipart/byteranges response without boundary")
+			}
+			r.multipartReader = multipart.NewReader(resp.Body, params["boundary"])
+			return r, nil
+		}
+
+		span, err := r.match(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		r.single = true
+		r.singleSpan = span
+		return r, nil
+	}
+
+	return nil, NewErrUnexpectedStatus(resp.StatusCode)
+}
+
+// Next returns the span and the body of the next part. It returns io.EOF
+// after the last part. A part body shorter than its span returns
+// io.ErrUnexpectedEOF.
+func (r *ByteRangesReader) Next() (ioutils.FileSpan, io.Reader, error) {
+	switch {
+	case r.multipartReader != nil:
+		return r.nextMultipart()
+	case r.single:
+		if r.current != nil {
+			return ioutils.FileSpan{}, nil, io.EOF
+		}
+		r.current = newSpanReader(r.body, r.singleSpan)
+		return r.singleSpan, r.current, nil
+	default:
+		return r.nextFull()
+	}
+}
+
+// Close closes the response body.
+func (r *ByteRangesReader) Close() error {
+	return r.body.Close()
+}
+
+func (r *ByteRangesReader) nextMultipart() (ioutils.FileSpan, io.Reader, error) {
+	part, err := r.multipartReader.NextPart()
+	if err != nil {
+		return ioutils.FileSpan{}, nil, err
+	}
+
+	span, err := r.match(part.Header.Get("Content-Range"))
+	if err != nil {
+		return ioutils.FileSpan{}, nil, err
+	}
+
+	return span, newSpanReader(part, span), nil
+}
+
+// initFull resolves the requested spans against the full body. The body can
+// only be read forward so the spans must not overlap.
+func (r *ByteRangesReader) initFull() error {
+	for _, span := range r.spans {
+		if r.Size >= 0 {
+			if span.Start >= r.Size {
+				// a server honoring the range would not return this span
+				continue
+			}
+			if span.End < 0 || span.End >= r.Size {
+				span.End = r.Size - 1
+			}
+		}
+		r.fullSpans = append(r.fullSpans, span)
+	}
+
+	sort.Slice(r.fullSpans, func(i, j int) bool {
+		return r.fullSpans[i].Start < r.fullSpans[j].Start
+	})
+
+	for i := 1; i < len(r.fullSpans); i++ {
+		prev := r.fullSpans[i-1]
+		if prev.End < 0 || prev.End >= r.fullSpans[i].Start {
+			return ErrInvalidRange
+		}
+	}
+
+	return nil
+}
+
+func (r *ByteRangesReader) nextFull() (ioutils.FileSpan, io.Reader, error) {
+	if r.current != nil {
+		// skip the unread rest of the previous part
+		if _, err := io.Copy(io.Discard, r.current); err != nil {
+			return ioutils.FileSpan{}, nil, err
+		}
+		r.fullOffset = r.currentSpan.End + 1
+	}
+
+	if len(r.fullSpans) == 0 {
+		return ioutils.FileSpan{}, nil, io.EOF
+	}
+	span := r.fullSpans[0]
+	r.fullSpans = r.fullSpans[1:]
+
+	n, err := io.CopyN(io.Discard, r.body, span.Start-r.fullOffset)
+	r.fullOffset += n
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return ioutils.FileSpan{}, nil, err
+	}
+
+	r.current = newSpanReader(r.body, span)
+	r.currentSpan = span
+
+	return span, r.current, nil
+}
+
+// match validates a Content-Range header value against the requested spans
+// which were not returned yet.
+func (r *ByteRangesReader) match(contentRange string) (ioutils.FileSpan, error) {
+	cr, err := ParseContentRange(contentRange)
+	if err != nil {
+		return ioutils.FileSpan{}, err
+	}
+	if cr.Unsatisfied {
+		return ioutils.FileSpan{}, ErrInvalidContentRange
+	}
+
+	if cr.Size >= 0 {
+		if r.Size >= 0 && cr.Size != r.Size {
+			return ioutils.FileSpan{}, ErrInvalidContentRange
+		}
+		r.Size = cr.Size
+	}
+
+	for i, span := range r.spans {
+		if r.returned[i] || span.Start != cr.Span.Start {
+			continue
+		}
+
+		end := span.End
+		if end < 0 || (r.Size >= 0 && end >= r.Size) {
+			if r.Size >= 0 {
+				end = r.Size - 1
+			} else {
+				end = cr.Span.End
+			}
+		}
+
+		if end == cr.Span.End {
+			r.returned[i] = true
+			return cr.Span, nil
+		}
+	}
+
+	return ioutils.FileSpan{}, ErrInvalidContentRange
+}
+
+// spanReader reads exactly the bytes of span from r. A negative span End
+// reads until EOF.
+type spanReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func newSpanReader(r io.Reader, span ioutils.FileSpan) *spanReader {
+	remaining := int64(-1)
+	if span.End >= 0 {
+		remaining = span.End - span.Start + 1
+	}
+
+	return &spanReader{
+		r:         r,
+		remaining: remaining,
+	}
+}
+
+func (r *spanReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return r.r.Read(p)
+	}
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+
+	if err == io.EOF {
+		if r.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		if n == 0 {
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+
+	return n, err
+}
@@ -0,0 +1,220 @@
+package httputils_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koofr/go-ioutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+type byteRangesPart struct {
+	Span ioutils.FileSpan
+	Data string
+}
+
+func readByteRanges(r *ByteRangesReader, readParts bool) ([]byteRangesPart, error) {
+	parts := []byteRangesPart{}
+	for {
+		span, reader, err := r.Next()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, err
+		}
+		part := byteRangesPart{Span: span}
+		if readParts {
+			data, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return parts, err
+			}
+			part.Data = string(data)
+		}
+		parts = append(parts, part)
+	}
+}
+
+var _ = Describe("ByteRangesReader", func() {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+
+	get := func(handler http.HandlerFunc, spans []ioutils.FileSpan) *http.Response {
+		rangeHeader, err := FormatRange(spans)
+		Expect(err).NotTo(HaveOccurred())
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Range", rangeHeader)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Result()
+	}
+
+	serveContent := func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}
+
+	serveFull := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		_, _ = w.Write([]byte(content))
+	}
+
+	It("should read a multipart/byteranges response", func() {
+		spans := []ioutils.FileSpan{{Start: 0, End: 3}, {Start: 10, End: 12}, {Start: 30, End: -1}}
+		resp := get(serveContent, spans)
+		Expect(resp.Header.Get("Content-Type")).To(HavePrefix("multipart/byteranges"))
+
+		r, err := NewByteRangesReader(resp, spans)
+		Expect(err).NotTo(HaveOccurred())
+		defer r.Close()
+
+		parts, err := readByteRanges(r, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parts).To(Equal([]byteRangesPart{
+			{Span: ioutils.FileSpan{Start: 0, End: 3}, Data: "0123"},
+			{Span: ioutils.FileSpan{Start: 10, End: 12}, Data: "abc"},
+			{Span: ioutils.FileSpan{Start: 30, End: 35}, Data: "uvwxyz"},
+		}))
+		Expect(r.Size).To(Equal(int64(36)))
+	})
+
+	It("should skip unread parts", func() {
+		spans := []ioutils.FileSpan{{Start: 0, End: 3}, {Start: 10, End: 12}}
+		r, err := NewByteRangesReader(get(serveContent, spans), spans)
+		Expect(err).NotTo(HaveOccurred())
+
+		parts, err := readByteRanges(r, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parts).To(HaveLen(2))
+	})
+
+	It("should read a single range response", func() {
+		spans := []ioutils.FileSpan{{Start: 10, End: 100}}
+		resp := get(serveContent, spans)
+		Expect(resp.StatusCode).To(Equal(http.StatusPartialContent))
+
+		r, err := NewByteRangesReader(resp, spans)
+		Expect(err).NotTo(HaveOccurred())
+
+		parts, err := readByteRanges(r, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parts).To(Equal([]byteRangesPart{
+			{Span: ioutils.FileSpan{Start: 10, End: 35}, Data: "abcdefghijklmnopqrstuvwxyz"},
+		}))
+	})
+
+	It("should cut the spans from a full response", func() {
+		spans := []ioutils.FileSpan{{Start: 30, End: -1}, {Start: 10, End: 12}, {Start: 0, End: 3}, {Start: 100, End: 200}}
+		resp := get(serveFull, spans)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		r, err := NewByteRangesReader(resp, spans)
+		Expect(err).NotTo(HaveOccurred())
+
+		parts, err := readByteRanges(r, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parts).To(Equal([]byteRangesPart{
+			{Span: ioutils.FileSpan{Start: 0, End: 3}, Data: "0123"},
+			{Span: ioutils.FileSpan{Start: 10, End: 12}, Data: "abc"},
+			{Span: ioutils.FileSpan{Start: 30, End: 35}, Data: "uvwxyz"},
+		}))
+	})
+
+	It("should skip unread parts of a full response", func() {
+		spans := []ioutils.FileSpan{{Start: 0, End: 3}, {Start: 10, End: 12}}
+		r, err := NewByteRangesReader(get(serveFull, spans), spans)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _, err = r.Next()
+		Expect(err).NotTo(HaveOccurred())
+		span, reader, err := r.Next()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(span).To(Equal(ioutils.FileSpan{Start: 10, End: 12}))
+		data, err := ioutil.ReadAll(reader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("abc"))
+	})
+
+	It("should not cut overlapping spans from a full response", func() {
+		spans := []ioutils.FileSpan{{Start: 0, End: 10}, {Start: 5, End: 12}}
+		_, err := NewByteRangesReader(get(serveFull, spans), spans)
+		Expect(err).To(Equal(ErrInvalidRange))
+	})
+
+	It("should reject parts which were not requested", func() {
+		resp := get(serveContent, []ioutils.FileSpan{{Start: 0, End: 3}, {Start: 10, End: 12}})
+
+		r, err := NewByteRangesReader(resp, []ioutils.FileSpan{{Start: 0, End: 3}, {Start: 10, End: 11}})
+		Expect(err).NotTo(HaveOccurred())
+
+		parts, err := readByteRanges(r, true)
+		Expect(err).To(Equal(ErrInvalidContentRange))
+		Expect(parts).To(HaveLen(1))
+	})
+
+	It("should reject a single range response which was not requested", func() {
+		resp := get(serveContent, []ioutils.FileSpan{{Start: 0, End: 3}})
+
+		_, err := NewByteRangesReader(resp, []ioutils.FileSpan{{Start: 1, End: 3}})
+		Expect(err).To(Equal(ErrInvalidContentRange))
+	})
+
+	It("should reject duplicate parts", func() {
+		spans := []ioutils.FileSpan{{Start: 0, End: 3}}
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "multipart/byteranges; boundary=b")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte("--b\r\nContent-Range: bytes 0-3/36\r\n\r\n0123\r\n--b\r\nContent-Range: bytes 0-3/36\r\n\r\n0123\r\n--b--\r\n"))
+		}
+
+		r, err := NewByteRangesReader(get(handler, spans), spans)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = readByteRanges(r, true)
+		Expect(err).To(Equal(ErrInvalidContentRange))
+	})
+
+	It("should detect truncated parts", func() {
+		spans := []ioutils.FileSpan{{Start: 0, End: 9}}
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "multipart/byteranges; boundary=b")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte("--b\r\nContent-Range: bytes 0-9/36\r\n\r\n0123\r\n--b--\r\n"))
+		}
+
+		r, err := NewByteRangesReader(get(handler, spans), spans)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = readByteRanges(r, true)
+		Expect(err).To(Equal(io.ErrUnexpectedEOF))
+	})
+
+	It("should detect a truncated full response", func() {
+		spans := []ioutils.FileSpan{{Start: 30, End: 40}}
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			ContentLength: -1,
+			Body:          io.NopCloser(bytes.NewReader([]byte("0123"))),
+		}
+
+		r, err := NewByteRangesReader(resp, spans)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = readByteRanges(r, true)
+		Expect(err).To(Equal(io.ErrUnexpectedEOF))
+	})
+
+	It("should return an error for other statuses", func() {
+		spans := []ioutils.FileSpan{{Start: 100, End: 200}}
+		_, err := NewByteRangesReader(get(serveContent, spans), spans)
+		Expect(err).To(Equal(NewErrUnexpectedStatus(http.StatusRequestedRangeNotSatisfiable)))
+	})
+})
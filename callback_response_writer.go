@@ -2,61 +2,227 @@ package httputils
 
 import (
 	"bufio"
+	"io"
 	"net"
 	"net/http"
+	"sync"
 )
 
+// CallbackHooks are invoked by CallbackResponseWriter. All hooks are optional.
+type CallbackHooks struct {
+	// BeforeWriteHeader is called exactly once before the header is written,
+	// either explicitly by WriteHeader or implicitly by Write, ReadFrom, Flush
+	// or Done. It may modify the header and returns the status code to write.
+	// Informational (1xx) responses other than 101 Switching Protocols do not
+	// trigger it.
+	BeforeWriteHeader func(statusCode int, h http.Header) int
+	// OnWrite is called after every Write and ReadFrom.
+	OnWrite  func(n int, err error)
+	OnFlush  func()
+	OnHijack func(conn net.Conn, err error)
+	// OnDone is called once by Done.
+	OnDone func(summary CallbackSummary)
+}
+
+type CallbackSummary struct {
+	StatusCode    int
+	BytesWritten  int64
+	HeaderWritten bool
+	Hijacked      bool
+}
+
+// CallbackResponseWriter is safe for use by a streaming goroutine
+// concurrently with Done.
 type CallbackResponseWriter struct {
 	http.ResponseWriter
-	beforeWriteHeader func()
+	passThrough
+	hooks CallbackHooks
+
+	beforeWriteHeaderOnce sync.Once
+	doneOnce              sync.Once
 
+	mu                      sync.Mutex
 	beforeWriteHeaderCalled bool
+	statusCode              int
+	bytesWritten            int64
+	hijacked                bool
 }
 
+// NewCallbackResponseWriter calls beforeWriteHeader before the header is
+// written to w. Pass Wrap() to handlers so that they see the same optional
+// interfaces as on w.
 func NewCallbackResponseWriter(w http.ResponseWriter, beforeWriteHeader func()) *CallbackResponseWriter {
-	return &CallbackResponseWriter{
-		ResponseWriter:    w,
-		beforeWriteHeader: beforeWriteHeader,
+	return NewCallbackResponseWriterHooks(w, CallbackHooks{
+		BeforeWriteHeader: func(statusCode int, h http.Header) int {
+			beforeWriteHeader()
+			return statusCode
+		},
+	})
+}
+
+// NewCallbackResponseWriterHooks is like NewCallbackResponseWriter with
+// hooks. Pass Wrap() to handlers.
+func NewCallbackResponseWriterHooks(w http.ResponseWriter, hooks CallbackHooks) *CallbackResponseWriter {
+	cw := &CallbackResponseWriter{
+		ResponseWriter: w,
+		hooks:          hooks,
 
 		beforeWriteHeaderCalled: false,
+		statusCode:              http.StatusOK,
 	}
+	cw.passThrough = passThrough{&cw.ResponseWriter}
+
+	return cw
+}
+
+// beforeWriteHeader calls the BeforeWriteHeader hook exactly once and returns
+// the status code to write. Concurrent callers wait until the hook returns.
+// If implicit is true and the hook changed the status code, the header is
+// written before returning.
+func (w *CallbackResponseWriter) beforeWriteHeader(statusCode int, implicit bool) int {
+	w.beforeWriteHeaderOnce.Do(func() {
+		if w.hooks.BeforeWriteHeader != nil {
+			statusCode = w.hooks.BeforeWriteHeader(statusCode, w.ResponseWriter.Header())
+		}
+
+		w.mu.Lock()
+		w.beforeWriteHeaderCalled = true
+		w.statusCode = statusCode
+		w.mu.Unlock()
+
+		if implicit && statusCode != http.StatusOK {
+			w.ResponseWriter.WriteHeader(statusCode)
+		}
+	})
+
+	return statusCode
+}
+
+// implicitWriteHeader calls the BeforeWriteHeader hook before an implicit
+// 200 OK header.
+func (w *CallbackResponseWriter) implicitWriteHeader() {
+	w.beforeWriteHeader(http.StatusOK, true)
 }
 
 func (w *CallbackResponseWriter) WriteHeader(statusCode int) {
-	w.beforeWriteHeader()
-	w.beforeWriteHeaderCalled = true
+	if statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		// informational responses are followed by the final response
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
 
-	w.ResponseWriter.WriteHeader(statusCode)
+	w.ResponseWriter.WriteHeader(w.beforeWriteHeader(statusCode, false))
 }
 
 func (w *CallbackResponseWriter) Write(b []byte) (int, error) {
-	if !w.beforeWriteHeaderCalled {
-		w.beforeWriteHeader()
-		w.beforeWriteHeaderCalled = true
+	w.implicitWriteHeader()
+
+	n, err := w.ResponseWriter.Write(b)
+
+	w.mu.Lock()
+	w.bytesWritten += int64(n)
+	w.mu.Unlock()
+
+	if w.hooks.OnWrite != nil {
+		w.hooks.OnWrite(n, err)
 	}
 
-	return w.ResponseWriter.Write(b)
+	return n, err
 }
 
 func (w *CallbackResponseWriter) Flush() {
-	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
-		flusher.Flush()
+	_ = w.FlushError()
+}
+
+// FlushError flushes the underlying writer using http.ResponseController and
+// returns http.ErrNotSupported if the underlying writer cannot be flushed.
+func (w *CallbackResponseWriter) FlushError() error {
+	w.implicitWriteHeader()
+
+	err := http.NewResponseController(w.ResponseWriter).Flush()
+
+	if w.hooks.OnFlush != nil {
+		w.hooks.OnFlush()
 	}
+
+	return err
 }
 
 func (w *CallbackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
-		return hijacker.Hijack()
+		conn, rw, err := hijacker.Hijack()
+		if err == nil {
+			w.mu.Lock()
+			w.hijacked = true
+			w.mu.Unlock()
+		}
+
+		if w.hooks.OnHijack != nil {
+			w.hooks.OnHijack(conn, err)
+		}
+
+		return conn, rw, err
 	}
 
 	return nil, nil, ErrResponseNotHijacker
 }
 
+func (w *CallbackResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	readerFrom, ok := w.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(writerOnly{w}, src)
+	}
+
+	w.implicitWriteHeader()
+
+	n, err := readerFrom.ReadFrom(src)
+
+	w.mu.Lock()
+	w.bytesWritten += n
+	w.mu.Unlock()
+
+	if w.hooks.OnWrite != nil {
+		w.hooks.OnWrite(int(n), err)
+	}
+
+	return n, err
+}
+
+// Wrap returns a http.ResponseWriter backed by w which implements exactly the
+// optional interfaces (http.Flusher, http.Hijacker, io.ReaderFrom,
+// http.Pusher, http.CloseNotifier) implemented by the underlying writer.
+func (w *CallbackResponseWriter) Wrap() http.ResponseWriter {
+	return wrapResponseWriter(w, w.ResponseWriter)
+}
+
+// Done must be called after the handler returns. It calls the
+// BeforeWriteHeader hook if nothing was written and then the OnDone hook.
 func (w *CallbackResponseWriter) Done() {
-	if !w.beforeWriteHeaderCalled {
-		w.beforeWriteHeader()
-		w.beforeWriteHeaderCalled = true
+	w.mu.Lock()
+	hijacked := w.hijacked
+	w.mu.Unlock()
+
+	if !hijacked {
+		w.implicitWriteHeader()
+	}
+
+	w.doneOnce.Do(func() {
+		if w.hooks.OnDone != nil {
+			w.hooks.OnDone(w.Summary())
+		}
+	})
+}
+
+func (w *CallbackResponseWriter) Summary() CallbackSummary {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return CallbackSummary{
+		StatusCode:    w.statusCode,
+		BytesWritten:  w.bytesWritten,
+		HeaderWritten: w.beforeWriteHeaderCalled,
+		Hijacked:      w.hijacked,
 	}
 }
 
-var _ http.ResponseWriter = (*CallbackResponseWriter)(nil)
+var _ optionalResponseWriter = (*CallbackResponseWriter)(nil)
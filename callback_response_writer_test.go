@@ -0,0 +1,278 @@
+package httputils_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+// syncResponseWriter is a http.ResponseWriter which is safe for concurrent
+// use, so that concurrency tests only detect races in the wrapper.
+type syncResponseWriter struct {
+	mu           sync.Mutex
+	header       http.Header
+	statusCodes  []int
+	bytesWritten int
+	flushes      int
+}
+
+func newSyncResponseWriter() *syncResponseWriter {
+	return &syncResponseWriter{header: http.Header{}}
+}
+
+func (w *syncResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *syncResponseWriter) WriteHeader(statusCode int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.statusCodes = append(w.statusCodes, statusCode)
+}
+
+func (w *syncResponseWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.bytesWritten += len(b)
+	return len(b), nil
+}
+
+func (w *syncResponseWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flushes++
+}
+
+var _ = Describe("CallbackResponseWriter", func() {
+	It("should call the callback before the first Write", func() {
+		rec := httptest.NewRecorder()
+		called := 0
+		w := NewCallbackResponseWriter(rec, func() {
+			called++
+			rec.Header().Set("X-Session", "refreshed")
+		})
+
+		_, _ = w.Write([]byte("a"))
+		_, _ = w.Write([]byte("b"))
+		w.Done()
+
+		Expect(called).To(Equal(1))
+		Expect(rec.Header().Get("X-Session")).To(Equal("refreshed"))
+		Expect(rec.Body.String()).To(Equal("ab"))
+	})
+
+	It("should call the callback in Done if nothing was written", func() {
+		called := 0
+		w := NewCallbackResponseWriter(httptest.NewRecorder(), func() {
+			called++
+		})
+
+		w.Done()
+
+		Expect(called).To(Equal(1))
+	})
+
+	Describe("hooks", func() {
+		It("should allow BeforeWriteHeader to see and rewrite the status", func() {
+			rec := httptest.NewRecorder()
+			var seenStatus int
+			w := NewCallbackResponseWriterHooks(rec, CallbackHooks{
+				BeforeWriteHeader: func(statusCode int, h http.Header) int {
+					seenStatus = statusCode
+					h.Set("Cache-Control", "no-store")
+					return http.StatusServiceUnavailable
+				},
+			})
+
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Done()
+
+			Expect(seenStatus).To(Equal(http.StatusInternalServerError))
+			Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(rec.Header().Get("Cache-Control")).To(Equal("no-store"))
+		})
+
+		It("should rewrite the implicit status of Write", func() {
+			rec := httptest.NewRecorder()
+			w := NewCallbackResponseWriterHooks(rec, CallbackHooks{
+				BeforeWriteHeader: func(statusCode int, h http.Header) int {
+					Expect(statusCode).To(Equal(http.StatusOK))
+					return http.StatusAccepted
+				},
+			})
+
+			_, _ = w.Write([]byte("hello"))
+
+			Expect(rec.Code).To(Equal(http.StatusAccepted))
+		})
+
+		It("should call OnWrite, OnFlush and OnDone", func() {
+			rec := httptest.NewRecorder()
+			var writes []int
+			flushes := 0
+			var summaries []CallbackSummary
+			w := NewCallbackResponseWriterHooks(rec, CallbackHooks{
+				OnWrite: func(n int, err error) {
+					Expect(err).NotTo(HaveOccurred())
+					writes = append(writes, n)
+				},
+				OnFlush: func() {
+					flushes++
+				},
+				OnDone: func(summary CallbackSummary) {
+					summaries = append(summaries, summary)
+				},
+			})
+
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("hello"))
+			w.Flush()
+			_, _ = w.Write([]byte("!"))
+			w.Done()
+			w.Done()
+
+			Expect(writes).To(Equal([]int{5, 1}))
+			Expect(flushes).To(Equal(1))
+			Expect(rec.Flushed).To(BeTrue())
+			Expect(summaries).To(Equal([]CallbackSummary{{
+				StatusCode:    http.StatusCreated,
+				BytesWritten:  6,
+				HeaderWritten: true,
+				Hijacked:      false,
+			}}))
+		})
+
+		It("should call OnHijack and skip BeforeWriteHeader in Done after hijack", func() {
+			beforeWriteHeaderCalled := false
+			var hijackedConn net.Conn
+			var summary CallbackSummary
+			w := NewCallbackResponseWriterHooks(newFullResponseWriter(), CallbackHooks{
+				BeforeWriteHeader: func(statusCode int, h http.Header) int {
+					beforeWriteHeaderCalled = true
+					return statusCode
+				},
+				OnHijack: func(conn net.Conn, err error) {
+					Expect(err).NotTo(HaveOccurred())
+					hijackedConn = conn
+				},
+				OnDone: func(s CallbackSummary) {
+					summary = s
+				},
+			})
+
+			conn, _, err := w.Hijack()
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+			w.Done()
+
+			Expect(hijackedConn).To(Equal(conn))
+			Expect(beforeWriteHeaderCalled).To(BeFalse())
+			Expect(summary.Hijacked).To(BeTrue())
+			Expect(summary.HeaderWritten).To(BeFalse())
+		})
+	})
+
+	Describe("exactly once", func() {
+		It("should not call the callback again on WriteHeader after Write", func() {
+			rec := httptest.NewRecorder()
+			called := 0
+			w := NewCallbackResponseWriter(rec, func() {
+				called++
+			})
+
+			_, _ = w.Write([]byte("hello"))
+			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Done()
+
+			Expect(called).To(Equal(1))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+		})
+
+		It("should not consume the hook for informational responses", func() {
+			w := newSyncResponseWriter()
+			var statusCodes []int
+			cw := NewCallbackResponseWriterHooks(w, CallbackHooks{
+				BeforeWriteHeader: func(statusCode int, h http.Header) int {
+					statusCodes = append(statusCodes, statusCode)
+					return statusCode
+				},
+			})
+
+			cw.WriteHeader(http.StatusContinue)
+			cw.WriteHeader(http.StatusEarlyHints)
+			cw.WriteHeader(http.StatusCreated)
+			cw.Done()
+
+			Expect(statusCodes).To(Equal([]int{http.StatusCreated}))
+			Expect(w.statusCodes).To(Equal([]int{http.StatusContinue, http.StatusEarlyHints, http.StatusCreated}))
+			Expect(cw.Summary().StatusCode).To(Equal(http.StatusCreated))
+		})
+
+		It("should consume the hook for 101 Switching Protocols", func() {
+			called := 0
+			cw := NewCallbackResponseWriter(newSyncResponseWriter(), func() {
+				called++
+			})
+
+			cw.WriteHeader(http.StatusSwitchingProtocols)
+			cw.Done()
+
+			Expect(called).To(Equal(1))
+		})
+
+		It("should call hooks exactly once from concurrent goroutines", func() {
+			w := newSyncResponseWriter()
+			var beforeWriteHeaderCalls, doneCalls atomic.Int32
+			var writes atomic.Int32
+			cw := NewCallbackResponseWriterHooks(w, CallbackHooks{
+				BeforeWriteHeader: func(statusCode int, h http.Header) int {
+					beforeWriteHeaderCalls.Add(1)
+					return statusCode
+				},
+				OnWrite: func(n int, err error) {
+					writes.Add(1)
+				},
+				OnDone: func(summary CallbackSummary) {
+					doneCalls.Add(1)
+				},
+			})
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(4)
+				go func() {
+					defer wg.Done()
+					_, _ = cw.Write([]byte("x"))
+				}()
+				go func() {
+					defer wg.Done()
+					cw.WriteHeader(http.StatusOK)
+				}()
+				go func() {
+					defer wg.Done()
+					cw.Flush()
+				}()
+				go func() {
+					defer wg.Done()
+					cw.Done()
+					_ = cw.Summary()
+				}()
+			}
+			wg.Wait()
+
+			Expect(beforeWriteHeaderCalls.Load()).To(Equal(int32(1)))
+			Expect(doneCalls.Load()).To(Equal(int32(1)))
+			Expect(writes.Load()).To(Equal(int32(10)))
+			Expect(cw.Summary().BytesWritten).To(Equal(int64(10)))
+			Expect(w.flushes).To(Equal(10))
+		})
+	})
+})
@@ -0,0 +1,122 @@
+package httputils
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CaptureConn counts bytes read from and written to a hijacked connection and
+// records the time until it is closed. It is safe for concurrent use.
+type CaptureConn struct {
+	net.Conn
+	Start time.Time
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	closedAt     atomic.Int64
+	closeOnce    sync.Once
+	onClose      func(c *CaptureConn)
+}
+
+func NewCaptureConn(conn net.Conn, onClose func(c *CaptureConn)) *CaptureConn {
+	return &CaptureConn{
+		Conn:    conn,
+		Start:   time.Now().UTC(),
+		onClose: onClose,
+	}
+}
+
+func (c *CaptureConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesRead.Add(int64(n))
+	return n, err
+}
+
+func (c *CaptureConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesWritten.Add(int64(n))
+	return n, err
+}
+
+// Close closes the connection. onClose is called after the first Close.
+func (c *CaptureConn) Close() error {
+	err := c.Conn.Close()
+
+	c.closeOnce.Do(func() {
+		c.closedAt.Store(time.Now().UnixNano())
+
+		if c.onClose != nil {
+			c.onClose(c)
+		}
+	})
+
+	return err
+}
+
+func (c *CaptureConn) BytesRead() int64 {
+	return c.bytesRead.Load()
+}
+
+func (c *CaptureConn) BytesWritten() int64 {
+	return c.bytesWritten.Load()
+}
+
+func (c *CaptureConn) Closed() bool {
+	return c.closedAt.Load() != 0
+}
+
+// Duration returns the time from hijack until Close or until now if the
+// connection is still open.
+func (c *CaptureConn) Duration() time.Duration {
+	if closedAt := c.closedAt.Load(); closedAt != 0 {
+		return time.Unix(0, closedAt).Sub(c.Start)
+	}
+	return time.Since(c.Start)
+}
+
+// wrapReadWriter returns a bufio.ReadWriter that reads from and writes to rw
+// while counting bytes in c. Writes are flushed to rw immediately so that the
+// returned writer's Flush reaches the connection.
+func (c *CaptureConn) wrapReadWriter(rw *bufio.ReadWriter) *bufio.ReadWriter {
+	var reader *bufio.Reader
+	if rw.Reader != nil {
+		reader = bufio.NewReaderSize(&captureConnReader{r: rw.Reader, c: c}, rw.Reader.Size())
+	}
+
+	var writer *bufio.Writer
+	if rw.Writer != nil {
+		writer = bufio.NewWriterSize(&captureConnWriter{w: rw.Writer, c: c}, rw.Writer.Size())
+	}
+
+	return bufio.NewReadWriter(reader, writer)
+}
+
+type captureConnReader struct {
+	r *bufio.Reader
+	c *CaptureConn
+}
+
+func (r *captureConnReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.c.bytesRead.Add(int64(n))
+	return n, err
+}
+
+type captureConnWriter struct {
+	w *bufio.Writer
+	c *CaptureConn
+}
+
+func (w *captureConnWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.c.bytesWritten.Add(int64(n))
+	if err != nil {
+		return n, err
+	}
+	return n, w.w.Flush()
+}
+
+var _ net.Conn = (*CaptureConn)(nil)
@@ -3,32 +3,85 @@ package httputils
 import (
 	"bufio"
 	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net"
 	"net/http"
+	"runtime"
+	"runtime/debug"
 	"time"
 )
 
 var ErrResponseNotHijacker = errors.New("response does not implement http.Hijacker")
 
+// MisusePolicy controls how CaptureResponseWriter reacts to invalid use, such
+// as calling WriteHeader twice or writing to a hijacked connection.
+type MisusePolicy int
+
+const (
+	MisusePanic MisusePolicy = iota
+	MisuseLog
+	MisuseIgnore
+)
+
+var DefaultMisusePolicy = MisusePanic
+
+type MisuseKind string
+
+const (
+	MisuseSuperfluousWriteHeader MisuseKind = "superfluous WriteHeader"
+	MisuseWriteHeaderAfterHijack MisuseKind = "WriteHeader after Hijack"
+	MisuseWriteAfterHijack       MisuseKind = "Write after Hijack"
+)
+
+type Misuse struct {
+	Kind    MisuseKind
+	Message string
+	// Caller is the file:line of the offending call.
+	Caller string
+	Stack  []byte
+}
+
 type CaptureResponseWriter struct {
 	http.ResponseWriter
+	passThrough
 	StatusCode     int
 	ResponseLength int64
 	Start          time.Time
 	WriteStart     time.Time
 	Hijacked       bool
 	HeaderWritten  bool
+	MisusePolicy   MisusePolicy
+	// Misuse records every invalid use regardless of MisusePolicy.
+	Misuse []Misuse
+	// Logf is used by MisuseLog. If nil, log.Printf is used.
+	Logf func(format string, v ...interface{})
+	// WrittenHeader is a snapshot of the header at the moment it was
+	// committed.
+	WrittenHeader    http.Header
+	DeclaredTrailers []string
+	// HijackedConn is set after a successful Hijack.
+	HijackedConn    *CaptureConn
+	OnHijackedClose func(w *CaptureResponseWriter)
 }
 
+// NewCaptureResponseWriter returns a writer capturing the response written to
+// w. Pass Wrap() to handlers so that they see the same optional interfaces as
+// on w.
 func NewCaptureResponseWriter(w http.ResponseWriter) *CaptureResponseWriter {
-	return &CaptureResponseWriter{
+	cw := &CaptureResponseWriter{
 		ResponseWriter: w,
 		StatusCode:     http.StatusOK,
 		ResponseLength: 0,
 		Start:          time.Now().UTC(),
 		Hijacked:       false,
 		HeaderWritten:  false,
+		MisusePolicy:   DefaultMisusePolicy,
 	}
+	cw.passThrough = passThrough{&cw.ResponseWriter}
+
+	return cw
 }
 
 func (w *CaptureResponseWriter) Write(buf []byte) (int, error) {
@@ -39,25 +92,84 @@ func (w *CaptureResponseWriter) Write(buf []byte) (int, error) {
 		w.HeaderWritten = true
 	}
 	if w.Hijacked {
-		panic("Write on hijacked CaptureResponseWriter")
+		w.reportMisuse(MisuseWriteAfterHijack, "Write on hijacked CaptureResponseWriter")
+		return 0, http.ErrHijacked
 	}
+	w.snapshotHeader()
 	n, err := w.ResponseWriter.Write(buf)
 	w.ResponseLength += int64(n)
 	return n, err
 }
 
 func (w *CaptureResponseWriter) WriteHeader(statusCode int) {
+	if w.Hijacked {
+		w.reportMisuse(MisuseWriteHeaderAfterHijack, "WriteHeader on hijacked CaptureResponseWriter")
+		return
+	}
 	if w.HeaderWritten {
-		panic("header already written")
+		w.reportMisuse(MisuseSuperfluousWriteHeader, "header already written")
+		return
 	}
-	if w.Hijacked {
-		panic("WriteHeader on hijacked CaptureResponseWriter")
+	if statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		// informational responses can be followed by the final response
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
 	}
 	w.StatusCode = statusCode
+	w.snapshotHeader()
 	w.ResponseWriter.WriteHeader(statusCode)
 	w.HeaderWritten = true
 }
 
+// snapshotHeader records the header map and the declared trailers at the
+// moment the header is committed.
+func (w *CaptureResponseWriter) snapshotHeader() {
+	if w.WrittenHeader != nil {
+		return
+	}
+	w.WrittenHeader = w.Header().Clone()
+	if w.WrittenHeader == nil {
+		w.WrittenHeader = http.Header{}
+	}
+	w.DeclaredTrailers = declaredTrailers(w.WrittenHeader)
+}
+
+// Trailers returns the trailers set so far, both the declared ones and the
+// ones set using http.TrailerPrefix.
+func (w *CaptureResponseWriter) Trailers() http.Header {
+	declared := w.DeclaredTrailers
+	if w.WrittenHeader == nil {
+		declared = declaredTrailers(w.Header())
+	}
+	return trailers(w.Header(), declared)
+}
+
+func (w *CaptureResponseWriter) reportMisuse(kind MisuseKind, message string) {
+	misuse := Misuse{
+		Kind:    kind,
+		Message: message,
+		Stack:   debug.Stack(),
+	}
+	// skip reportMisuse and the CaptureResponseWriter method
+	if _, file, line, ok := runtime.Caller(2); ok {
+		misuse.Caller = fmt.Sprintf("%s:%d", file, line)
+	}
+
+	w.Misuse = append(w.Misuse, misuse)
+
+	switch w.MisusePolicy {
+	case MisuseIgnore:
+	case MisuseLog:
+		logf := w.Logf
+		if logf == nil {
+			logf = log.Printf
+		}
+		logf("httputils: %s called from %s\n%s", message, misuse.Caller, misuse.Stack)
+	default:
+		panic(message)
+	}
+}
+
 func (w *CaptureResponseWriter) Duration() time.Duration {
 	return time.Since(w.Start)
 }
@@ -70,16 +182,75 @@ func (w *CaptureResponseWriter) WriteDuration() time.Duration {
 }
 
 func (w *CaptureResponseWriter) Flush() {
-	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
-		flusher.Flush()
+	_ = w.FlushError()
+}
+
+// FlushError flushes the underlying writer using http.ResponseController and
+// returns http.ErrNotSupported if the underlying writer cannot be flushed.
+// Flushing commits the header, so later WriteHeader calls are misuse.
+func (w *CaptureResponseWriter) FlushError() error {
+	if !w.Hijacked {
+		w.snapshotHeader()
+		w.HeaderWritten = true
 	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
 }
 
+// Hijack hijacks the underlying connection. The returned connection and
+// bufio.ReadWriter are wrapped in HijackedConn so that the traffic of upgraded
+// connections is counted. OnHijackedClose is called when the connection is
+// closed.
 func (w *CaptureResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
 		w.Hijacked = true
-		return hijacker.Hijack()
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			return conn, rw, err
+		}
+
+		w.HijackedConn = NewCaptureConn(conn, func(c *CaptureConn) {
+			if w.OnHijackedClose != nil {
+				w.OnHijackedClose(w)
+			}
+		})
+
+		if rw != nil {
+			rw = w.HijackedConn.wrapReadWriter(rw)
+		}
+
+		return w.HijackedConn, rw, nil
 	}
 
 	return nil, nil, ErrResponseNotHijacker
 }
+
+func (w *CaptureResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	readerFrom, ok := w.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(writerOnly{w}, src)
+	}
+
+	if w.WriteStart.IsZero() {
+		w.WriteStart = time.Now()
+	}
+	if !w.HeaderWritten {
+		w.HeaderWritten = true
+	}
+	if w.Hijacked {
+		w.reportMisuse(MisuseWriteAfterHijack, "ReadFrom on hijacked CaptureResponseWriter")
+		return 0, http.ErrHijacked
+	}
+	w.snapshotHeader()
+	n, err := readerFrom.ReadFrom(src)
+	w.ResponseLength += n
+	return n, err
+}
+
+// Wrap returns a http.ResponseWriter backed by w which implements exactly the
+// optional interfaces (http.Flusher, http.Hijacker, io.ReaderFrom,
+// http.Pusher, http.CloseNotifier) implemented by the underlying writer.
+func (w *CaptureResponseWriter) Wrap() http.ResponseWriter {
+	return wrapResponseWriter(w, w.ResponseWriter)
+}
+
+var _ optionalResponseWriter = (*CaptureResponseWriter)(nil)
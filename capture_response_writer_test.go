@@ -0,0 +1,184 @@
+package httputils_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+var _ = Describe("CaptureResponseWriter", func() {
+	It("should capture status code and response length", func() {
+		rec := httptest.NewRecorder()
+		w := NewCaptureResponseWriter(rec)
+
+		w.WriteHeader(http.StatusCreated)
+		_, err := w.Write([]byte("hello"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(w.StatusCode).To(Equal(http.StatusCreated))
+		Expect(w.ResponseLength).To(Equal(int64(5)))
+		Expect(w.HeaderWritten).To(BeTrue())
+		Expect(rec.Code).To(Equal(http.StatusCreated))
+	})
+
+	Describe("misuse", func() {
+		It("should panic on superfluous WriteHeader by default", func() {
+			w := NewCaptureResponseWriter(httptest.NewRecorder())
+			w.WriteHeader(http.StatusOK)
+
+			Expect(func() { w.WriteHeader(http.StatusOK) }).To(PanicWith("header already written"))
+			Expect(w.Misuse).To(HaveLen(1))
+			Expect(w.Misuse[0].Kind).To(Equal(MisuseSuperfluousWriteHeader))
+		})
+
+		It("should log superfluous WriteHeader with the caller", func() {
+			rec := httptest.NewRecorder()
+			w := NewCaptureResponseWriter(rec)
+			w.MisusePolicy = MisuseLog
+			var logs []string
+			w.Logf = func(format string, v ...interface{}) {
+				logs = append(logs, fmt.Sprintf(format, v...))
+			}
+
+			_, _ = w.Write([]byte("hello"))
+			w.WriteHeader(http.StatusInternalServerError)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(w.StatusCode).To(Equal(http.StatusOK))
+			Expect(w.Misuse).To(HaveLen(1))
+			Expect(w.Misuse[0].Caller).To(ContainSubstring("capture_response_writer_test.go:"))
+			Expect(w.Misuse[0].Stack).NotTo(BeEmpty())
+			Expect(logs).To(HaveLen(1))
+			Expect(logs[0]).To(HavePrefix("httputils: header already written called from "))
+			Expect(logs[0]).To(ContainSubstring("capture_response_writer_test.go:"))
+		})
+
+		It("should ignore writes after hijack", func() {
+			w := NewCaptureResponseWriter(newFullResponseWriter())
+			w.MisusePolicy = MisuseIgnore
+
+			conn, _, err := w.Hijack()
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			n, err := w.Write([]byte("hello"))
+			Expect(n).To(Equal(0))
+			Expect(err).To(Equal(http.ErrHijacked))
+			w.WriteHeader(http.StatusOK)
+
+			Expect(w.Misuse).To(HaveLen(2))
+			Expect(w.Misuse[0].Kind).To(Equal(MisuseWriteAfterHijack))
+			Expect(w.Misuse[1].Kind).To(Equal(MisuseWriteHeaderAfterHijack))
+		})
+
+		It("should report WriteHeader after Flush", func() {
+			rec := httptest.NewRecorder()
+			w := NewCaptureResponseWriter(rec)
+			w.MisusePolicy = MisuseIgnore
+
+			w.Flush()
+			w.WriteHeader(http.StatusInternalServerError)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(w.StatusCode).To(Equal(http.StatusOK))
+			Expect(w.Misuse).To(HaveLen(1))
+			Expect(w.Misuse[0].Kind).To(Equal(MisuseSuperfluousWriteHeader))
+		})
+
+		It("should allow informational responses before the final response", func() {
+			w := NewCaptureResponseWriter(httptest.NewRecorder())
+
+			w.Header().Set("Link", "</style.css>; rel=preload")
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusCreated)
+
+			Expect(w.Misuse).To(BeEmpty())
+			Expect(w.StatusCode).To(Equal(http.StatusCreated))
+			Expect(w.HeaderWritten).To(BeTrue())
+		})
+	})
+
+	Describe("Hijack", func() {
+		It("should count hijacked connection traffic", func() {
+			const upgradeResponse = "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n"
+
+			closed := make(chan *CaptureResponseWriter, 1)
+
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				w := NewCaptureResponseWriter(rw)
+				w.OnHijackedClose = func(w *CaptureResponseWriter) {
+					closed <- w
+				}
+
+				conn, brw, err := w.Hijack()
+				if err != nil {
+					panic(err)
+				}
+
+				_, _ = brw.WriteString(upgradeResponse)
+				_ = brw.Flush()
+
+				buf := make([]byte, 4)
+				_, _ = io.ReadFull(brw, buf)
+
+				time.Sleep(10 * time.Millisecond)
+
+				_, _ = conn.Write([]byte("pong"))
+				_ = conn.Close()
+			}))
+			defer server.Close()
+
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\nping"))
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err := io.ReadAll(conn)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(upgradeResponse + "pong"))
+
+			var w *CaptureResponseWriter
+			Eventually(closed).Should(Receive(&w))
+
+			Expect(w.Hijacked).To(BeTrue())
+			Expect(w.HijackedConn.Closed()).To(BeTrue())
+			Expect(w.HijackedConn.BytesRead()).To(Equal(int64(4)))
+			Expect(w.HijackedConn.BytesWritten()).To(Equal(int64(len(upgradeResponse) + 4)))
+			Expect(w.HijackedConn.Duration()).To(BeNumerically(">=", 10*time.Millisecond))
+		})
+
+		It("should call the close callback once", func() {
+			w := NewCaptureResponseWriter(newFullResponseWriter())
+			calls := 0
+			w.OnHijackedClose = func(w *CaptureResponseWriter) {
+				calls++
+			}
+
+			conn, _, err := w.Hijack()
+			Expect(err).NotTo(HaveOccurred())
+			_ = conn.Close()
+			_ = conn.Close()
+
+			Expect(calls).To(Equal(1))
+		})
+
+		It("should return ErrResponseNotHijacker if the underlying writer is not a Hijacker", func() {
+			w := NewCaptureResponseWriter(httptest.NewRecorder())
+
+			_, _, err := w.Hijack()
+			Expect(err).To(Equal(ErrResponseNotHijacker))
+			Expect(w.Hijacked).To(BeFalse())
+			Expect(w.HijackedConn).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,73 @@
+package httputils
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/koofr/go-ioutils"
+)
+
+var ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+
+// decodeContentEncoding returns a reader which decodes body according to the
+// Content-Encoding header value. Multiple encodings are decoded in reverse
+// order of application.
+func decodeContentEncoding(contentEncoding string, body io.ReadCloser) (io.ReadCloser, error) {
+	if contentEncoding == "" {
+		return body, nil
+	}
+
+	encodings := strings.Split(contentEncoding, ",")
+
+	reader := io.Reader(body)
+
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+
+		switch encoding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			gzipReader, err := gzip.NewReader(reader)
+			if err != nil {
+				return nil, err
+			}
+			reader = gzipReader
+		case "deflate":
+			reader = newDeflateReader(reader)
+		default:
+			return nil, NewErrInvalidContentType(fmt.Errorf("%w: %s", ErrUnsupportedContentEncoding, encoding))
+		}
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, body}, nil
+}
+
+// newDeflateReader decodes "deflate" bodies which should be zlib streams
+// (RFC 1950) but are sent as raw deflate streams (RFC 1951) by some clients.
+func newDeflateReader(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(2)
+	if err == nil && isZlibHeader(header) {
+		zlibReader, err := zlib.NewReader(br)
+		if err == nil {
+			return zlibReader
+		}
+		return ioutils.NewErrorReader(err)
+	}
+
+	return flate.NewReader(br)
+}
+
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && header[0]>>4 <= 7 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
@@ -0,0 +1,148 @@
+package httputils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/koofr/go-ioutils"
+)
+
+var ErrInvalidContentRange = errors.New("invalid content range")
+
+// ContentRange is a parsed Content-Range header value.
+type ContentRange struct {
+	// Span is the inclusive byte range of the part. It is zero if Unsatisfied
+	// is set.
+	Span ioutils.FileSpan
+	// Unsatisfied is set for "bytes */size" values sent with 416 responses.
+	Unsatisfied bool
+	// Size is the complete length of the representation or -1 if unknown.
+	Size int64
+}
+
+// FormatRange builds a Range request header value from spans. A span with a
+// negative End extends to the end of the file (e.g. "bytes=500-").
+func FormatRange(spans []ioutils.FileSpan) (string, error) {
+	if len(spans) == 0 {
+		return "", ErrInvalidRange
+	}
+
+	var sb strings.Builder
+	sb.WriteString("bytes=")
+
+	for i, span := range spans {
+		if span.Start < 0 || (span.End >= 0 && span.End < span.Start) {
+			return "", ErrInvalidRange
+		}
+
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(strconv.FormatInt(span.Start, 10))
+		sb.WriteByte('-')
+		if span.End >= 0 {
+			sb.WriteString(strconv.FormatInt(span.End, 10))
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// ParseContentRange parses a Content-Range response header value as defined
+// in RFC 7233 Section 4.2, e.g. "bytes 0-99/1234", "bytes 0-99/*" or
+// "bytes */1234".
+func ParseContentRange(s string) (ContentRange, error) {
+	const b = "bytes "
+	if !strings.HasPrefix(s, b) {
+		return ContentRange{}, ErrInvalidContentRange
+	}
+	s = s[len(b):]
+
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return ContentRange{}, ErrInvalidContentRange
+	}
+	rangePart, sizePart := s[:i], s[i+1:]
+
+	cr := ContentRange{Size: -1}
+
+	if sizePart != "*" {
+		size, ok := parseContentRangeInt(sizePart)
+		if !ok {
+			return ContentRange{}, ErrInvalidContentRange
+		}
+		cr.Size = size
+	}
+
+	if rangePart == "*" {
+		// the complete length is required for unsatisfied ranges
+		if cr.Size < 0 {
+			return ContentRange{}, ErrInvalidContentRange
+		}
+		cr.Unsatisfied = true
+		return cr, nil
+	}
+
+	i = strings.IndexByte(rangePart, '-')
+	if i < 0 {
+		return ContentRange{}, ErrInvalidContentRange
+	}
+
+	start, ok := parseContentRangeInt(rangePart[:i])
+	if !ok {
+		return ContentRange{}, ErrInvalidContentRange
+	}
+	end, ok := parseContentRangeInt(rangePart[i+1:])
+	if !ok {
+		return ContentRange{}, ErrInvalidContentRange
+	}
+	if end < start || (cr.Size >= 0 && end >= cr.Size) {
+		return ContentRange{}, ErrInvalidContentRange
+	}
+
+	cr.Span = ioutils.FileSpan{Start: start, End: end}
+
+	return cr, nil
+}
+
+// FormatContentRange builds a Content-Range response header value. It
+// validates cr the same way ParseContentRange does.
+func FormatContentRange(cr ContentRange) (string, error) {
+	size := "*"
+	if cr.Size >= 0 {
+		size = strconv.FormatInt(cr.Size, 10)
+	}
+
+	if cr.Unsatisfied {
+		if cr.Size < 0 {
+			return "", ErrInvalidContentRange
+		}
+		return "bytes */" + size, nil
+	}
+
+	span := cr.Span
+	if span.Start < 0 || span.End < span.Start || (cr.Size >= 0 && span.End >= cr.Size) {
+		return "", ErrInvalidContentRange
+	}
+
+	return "bytes " + strconv.FormatInt(span.Start, 10) + "-" + strconv.FormatInt(span.End, 10) + "/" + size, nil
+}
+
+// parseContentRangeInt parses a non-negative decimal integer. Unlike
+// strconv.ParseInt it does not accept signs.
+func parseContentRangeInt(s string) (int64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
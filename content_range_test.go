@@ -0,0 +1,102 @@
+package httputils_test
+
+import (
+	"github.com/koofr/go-ioutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+var _ = Describe("FormatRange", func() {
+	DescribeTable(
+		"Ranges",
+		func(spans []ioutils.FileSpan, shouldSucceed bool, expected string) {
+			s, err := FormatRange(spans)
+			if shouldSucceed {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s).To(Equal(expected))
+			} else {
+				Expect(err).To(Equal(ErrInvalidRange))
+			}
+		},
+		Entry("single range", []ioutils.FileSpan{{Start: 0, End: 99}}, true, "bytes=0-99"),
+		Entry("single byte", []ioutils.FileSpan{{Start: 0, End: 0}}, true, "bytes=0-0"),
+		Entry("open end", []ioutils.FileSpan{{Start: 500, End: -1}}, true, "bytes=500-"),
+		Entry("multiple ranges", []ioutils.FileSpan{{Start: 0, End: 99}, {Start: 200, End: 299}}, true, "bytes=0-99,200-299"),
+		Entry("no spans", []ioutils.FileSpan{}, false, ""),
+		Entry("negative start", []ioutils.FileSpan{{Start: -1, End: 5}}, false, ""),
+		Entry("start greater than end", []ioutils.FileSpan{{Start: 10, End: 5}}, false, ""),
+		Entry("any range is incorrect", []ioutils.FileSpan{{Start: 0, End: 5}, {Start: 10, End: 5}}, false, ""),
+	)
+
+	It("should round trip with ParseRange", func() {
+		spans := []ioutils.FileSpan{{Start: 40, End: 80}, {Start: 100, End: 199}}
+		s, err := FormatRange(spans)
+		Expect(err).NotTo(HaveOccurred())
+
+		parsed, hasEnd, err := ParseRange(s, 1000)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed).To(Equal(spans))
+		Expect(hasEnd).To(BeTrue())
+	})
+})
+
+var _ = Describe("ParseContentRange", func() {
+	DescribeTable(
+		"Content ranges",
+		func(s string, shouldSucceed bool, expected ContentRange) {
+			cr, err := ParseContentRange(s)
+			if shouldSucceed {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cr).To(Equal(expected))
+			} else {
+				Expect(err).To(Equal(ErrInvalidContentRange))
+			}
+		},
+		Entry("range with size", "bytes 0-99/1234", true, ContentRange{Span: ioutils.FileSpan{Start: 0, End: 99}, Size: 1234}),
+		Entry("last byte", "bytes 1233-1233/1234", true, ContentRange{Span: ioutils.FileSpan{Start: 1233, End: 1233}, Size: 1234}),
+		Entry("unknown size", "bytes 0-99/*", true, ContentRange{Span: ioutils.FileSpan{Start: 0, End: 99}, Size: -1}),
+		Entry("unsatisfied", "bytes */1234", true, ContentRange{Unsatisfied: true, Size: 1234}),
+		Entry("empty", "", false, ContentRange{}),
+		Entry("other unit", "items 0-99/1234", false, ContentRange{}),
+		Entry("range header syntax", "bytes=0-99/1234", false, ContentRange{}),
+		Entry("missing size", "bytes 0-99", false, ContentRange{}),
+		Entry("empty size", "bytes 0-99/", false, ContentRange{}),
+		Entry("unsatisfied unknown size", "bytes */*", false, ContentRange{}),
+		Entry("missing end", "bytes 0-/1234", false, ContentRange{}),
+		Entry("missing start", "bytes -99/1234", false, ContentRange{}),
+		Entry("negative start", "bytes -1-99/1234", false, ContentRange{}),
+		Entry("signed end", "bytes 0-+99/1234", false, ContentRange{}),
+		Entry("start greater than end", "bytes 100-99/1234", false, ContentRange{}),
+		Entry("end not less than size", "bytes 0-1234/1234", false, ContentRange{}),
+		Entry("spaces", "bytes 0 - 99/1234", false, ContentRange{}),
+		Entry("overflow", "bytes 0-99/99999999999999999999", false, ContentRange{}),
+	)
+})
+
+var _ = Describe("FormatContentRange", func() {
+	DescribeTable(
+		"Content ranges",
+		func(cr ContentRange, shouldSucceed bool, expected string) {
+			s, err := FormatContentRange(cr)
+			if shouldSucceed {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(s).To(Equal(expected))
+
+				parsed, err := ParseContentRange(s)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(parsed).To(Equal(cr))
+			} else {
+				Expect(err).To(Equal(ErrInvalidContentRange))
+			}
+		},
+		Entry("range with size", ContentRange{Span: ioutils.FileSpan{Start: 0, End: 99}, Size: 1234}, true, "bytes 0-99/1234"),
+		Entry("unknown size", ContentRange{Span: ioutils.FileSpan{Start: 0, End: 99}, Size: -1}, true, "bytes 0-99/*"),
+		Entry("unsatisfied", ContentRange{Unsatisfied: true, Size: 1234}, true, "bytes */1234"),
+		Entry("unsatisfied unknown size", ContentRange{Unsatisfied: true, Size: -1}, false, ""),
+		Entry("start greater than end", ContentRange{Span: ioutils.FileSpan{Start: 100, End: 99}, Size: 1234}, false, ""),
+		Entry("end not less than size", ContentRange{Span: ioutils.FileSpan{Start: 0, End: 1234}, Size: 1234}, false, ""),
+		Entry("negative start", ContentRange{Span: ioutils.FileSpan{Start: -1, End: 5}, Size: -1}, false, ""),
+	)
+})
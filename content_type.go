@@ -0,0 +1,71 @@
+package httputils
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// ContentTypePolicy controls which request Content-Type values are accepted
+// by the JSON request helpers.
+type ContentTypePolicy struct {
+	// Allowed lists the accepted media types. Defaults to application/json.
+	Allowed []string
+	// AllowJSONSuffix accepts any media type with the +json structured syntax
+	// suffix, e.g. application/merge-patch+json.
+	AllowJSONSuffix bool
+	// AllowMissing accepts requests without a Content-Type header.
+	AllowMissing bool
+}
+
+var defaultContentTypePolicy = &ContentTypePolicy{
+	Allowed: []string{"application/json"},
+}
+
+func (p *ContentTypePolicy) check(contentType string) error {
+	if contentType == "" && p.AllowMissing {
+		return nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return NewErrInvalidContentType(err)
+	}
+
+	if charset, ok := params["charset"]; ok && !isUTF8Charset(charset) {
+		return NewErrInvalidContentType(fmt.Errorf("unsupported charset: %s", charset))
+	}
+
+	allowed := p.Allowed
+	if len(allowed) == 0 {
+		allowed = defaultContentTypePolicy.Allowed
+	}
+
+	for _, allowedMediaType := range allowed {
+		if mediaType == allowedMediaType {
+			return nil
+		}
+	}
+
+	if p.AllowJSONSuffix && strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+
+	expected := strings.Join(allowed, ", ")
+	if len(allowed) > 1 {
+		expected = "one of " + expected
+	}
+	if p.AllowJSONSuffix {
+		expected += " or a +json media type"
+	}
+
+	return NewErrInvalidContentType(fmt.Errorf("expected Content-Type to be %s but got: %s", expected, mediaType))
+}
+
+func isUTF8Charset(charset string) bool {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,368 @@
+package httputils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/koofr/go-ioutils"
+)
+
+var ErrRangeNotSupported = errors.New("server does not support range requests")
+var ErrDownloadChanged = errors.New("resource changed during download")
+var ErrDownloadSizeUnknown = errors.New("download size unknown")
+
+type ErrUnexpectedStatus struct {
+	StatusCode int
+}
+
+func NewErrUnexpectedStatus(statusCode int) *ErrUnexpectedStatus {
+	return &ErrUnexpectedStatus{StatusCode: statusCode}
+}
+
+func (e *ErrUnexpectedStatus) Error() string {
+	return fmt.Sprintf("unexpected status: %d", e.StatusCode)
+}
+
+// Downloader downloads a URL to an io.WriterAt using range requests. Failed
+// requests are resumed from the last written byte with an If-Range header so
+// that the parts of a changed resource are never mixed.
+type Downloader struct {
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+	// Header is added to every request.
+	Header http.Header
+	// ChunkSize splits the download into range requests of ChunkSize bytes.
+	// If 0, the download is a single (resumable) request.
+	ChunkSize int64
+	// Concurrency is the number of chunks downloaded in parallel. Defaults
+	// to 1.
+	Concurrency int
+	// MaxRetries is the number of retries per chunk after network errors and
+	// 5xx responses.
+	MaxRetries int
+	RetryDelay time.Duration
+	// RequireRanges fails the download with ErrRangeNotSupported if the server
+	// ignores the Range header. Otherwise the full response is downloaded
+	// without resuming.
+	RequireRanges bool
+}
+
+type DownloadResult struct {
+	Size            int64
+	ETag            string
+	LastModified    string
+	RangesSupported bool
+}
+
+// ifRange returns the validator for If-Range requests. Weak ETags cannot be
+// used in If-Range (RFC 7233 Section 3.2).
+func (r *DownloadResult) ifRange() string {
+	if r.ETag != "" && !strings.HasPrefix(r.ETag, "W/") {
+		return r.ETag
+	}
+	return r.LastModified
+}
+
+// Download downloads url to w and returns the size and validators of the
+// downloaded resource.
+func (d *Downloader) Download(ctx context.Context, url string, w io.WriterAt) (*DownloadResult, error) {
+	var res *DownloadResult
+	var span ioutils.FileSpan
+	var n int64
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		res, span, n, err = d.downloadFirst(ctx, url, w)
+		if err == nil {
+			break
+		}
+		if attempt >= d.MaxRetries || !isRetryableDownloadError(err) {
+			return nil, err
+		}
+		if err := d.sleep(ctx); err != nil {
+			return nil, err
+		}
+		if res != nil && res.RangesSupported {
+			// resume the rest of the first chunk with the remaining retries
+			rest := ioutils.FileSpan{Start: span.Start + n, End: span.End}
+			if err := d.downloadChunk(ctx, url, rest, res, w, attempt+1); err != nil {
+				return nil, err
+			}
+			n = span.End - span.Start + 1
+			break
+		}
+		// without range support the download has to be restarted
+	}
+
+	chunks := []ioutils.FileSpan{}
+	if span.Start+n <= span.End {
+		chunks = append(chunks, ioutils.FileSpan{Start: span.Start + n, End: span.End})
+	}
+	for start := span.End + 1; start < res.Size; {
+		end := res.Size - 1
+		if d.ChunkSize > 0 && start+d.ChunkSize-1 < end {
+			end = start + d.ChunkSize - 1
+		}
+		chunks = append(chunks, ioutils.FileSpan{Start: start, End: end})
+		start = end + 1
+	}
+
+	if err := d.downloadChunks(ctx, url, chunks, res, w); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// downloadFirst requests the first chunk to learn the size and validators of
+// the resource. It returns the span of the response and the number of bytes
+// written.
+func (d *Downloader) downloadFirst(ctx context.Context, url string, w io.WriterAt) (*DownloadResult, ioutils.FileSpan, int64, error) {
+	requested := ioutils.FileSpan{Start: 0, End: -1}
+	if d.ChunkSize > 0 {
+		requested.End = d.ChunkSize - 1
+	}
+
+	resp, err := d.request(ctx, url, requested, "")
+	if err != nil {
+		return nil, ioutils.FileSpan{}, 0, err
+	}
+	defer resp.Body.Close()
+
+	res := &DownloadResult{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if d.RequireRanges {
+			return nil, ioutils.FileSpan{}, 0, ErrRangeNotSupported
+		}
+
+		n, err := io.Copy(io.NewOffsetWriter(w, 0), resp.Body)
+		if err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, ioutils.FileSpan{}, n, err
+		}
+		res.Size = n
+
+		return res, ioutils.FileSpan{Start: 0, End: n - 1}, n, nil
+
+	case http.StatusPartialContent:
+		cr, err := ParseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, ioutils.FileSpan{}, 0, err
+		}
+		if cr.Size < 0 {
+			return nil, ioutils.FileSpan{}, 0, ErrDownloadSizeUnknown
+		}
+		span := requested
+		if span.End < 0 || span.End >= cr.Size {
+			span.End = cr.Size - 1
+		}
+		if cr.Span != span {
+			return nil, ioutils.FileSpan{}, 0, ErrInvalidContentRange
+		}
+		res.Size = cr.Size
+		res.RangesSupported = true
+
+		n, err := copySpan(w, span, resp.Body)
+		return res, span, n, err
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the only unsatisfiable first range is the one of an empty resource
+		cr, err := ParseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || !cr.Unsatisfied || cr.Size != 0 {
+			return nil, ioutils.FileSpan{}, 0, NewErrUnexpectedStatus(resp.StatusCode)
+		}
+		res.RangesSupported = true
+
+		return res, ioutils.FileSpan{Start: 0, End: -1}, 0, nil
+	}
+
+	return nil, ioutils.FileSpan{}, 0, NewErrUnexpectedStatus(resp.StatusCode)
+}
+
+func (d *Downloader) downloadChunks(ctx context.Context, url string, chunks []ioutils.FileSpan, res *DownloadResult, w io.WriterAt) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := d.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	spans := make(chan ioutils.FileSpan)
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for span := range spans {
+				if err := d.downloadChunk(ctx, url, span, res, w, 0); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+
+loop:
+	for _, span := range chunks {
+		select {
+		case spans <- span:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(spans)
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ctx.Err()
+}
+
+// downloadChunk downloads span and resumes it after retryable errors. attempt
+// is the number of attempts already used for span.
+func (d *Downloader) downloadChunk(ctx context.Context, url string, span ioutils.FileSpan, res *DownloadResult, w io.WriterAt, attempt int) error {
+	for ; ; attempt++ {
+		n, err := d.downloadSpan(ctx, url, span, res, w)
+		if err == nil {
+			return nil
+		}
+		if attempt >= d.MaxRetries || !isRetryableDownloadError(err) {
+			return err
+		}
+		span.Start += n
+		if err := d.sleep(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Downloader) downloadSpan(ctx context.Context, url string, span ioutils.FileSpan, res *DownloadResult, w io.WriterAt) (int64, error) {
+	resp, err := d.request(ctx, url, span, res.ifRange())
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK, http.StatusRequestedRangeNotSatisfiable:
+		// the server supported ranges for the first request so a full
+		// response means that If-Range did not match
+		return 0, ErrDownloadChanged
+	default:
+		return 0, NewErrUnexpectedStatus(resp.StatusCode)
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" && res.ETag != "" && etag != res.ETag {
+		return 0, ErrDownloadChanged
+	}
+
+	cr, err := ParseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return 0, err
+	}
+	if cr.Span != span || cr.Size != res.Size {
+		return 0, ErrInvalidContentRange
+	}
+
+	return copySpan(w, span, resp.Body)
+}
+
+func (d *Downloader) request(ctx context.Context, url string, span ioutils.FileSpan, ifRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range d.Header {
+		req.Header[key] = values
+	}
+
+	rangeHeader, err := FormatRange([]ioutils.FileSpan{span})
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", rangeHeader)
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	// offsets refer to the unencoded representation
+	req.Header.Set("Accept-Encoding", "identity")
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(req)
+}
+
+func (d *Downloader) sleep(ctx context.Context) error {
+	if d.RetryDelay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d.RetryDelay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// copySpan writes the body of a range response to w. A body shorter than
+// span is reported as io.ErrUnexpectedEOF.
+func copySpan(w io.WriterAt, span ioutils.FileSpan, body io.Reader) (int64, error) {
+	length := span.End - span.Start + 1
+
+	n, err := io.Copy(io.NewOffsetWriter(w, span.Start), io.LimitReader(body, length))
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+func isRetryableDownloadError(err error) bool {
+	var errUnexpectedStatus *ErrUnexpectedStatus
+
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, ErrDownloadChanged), errors.Is(err, ErrRangeNotSupported), errors.Is(err, ErrDownloadSizeUnknown):
+		return false
+	case errors.Is(err, ErrInvalidContentRange), errors.Is(err, ErrInvalidRange):
+		return false
+	case errors.As(err, &errUnexpectedStatus):
+		return errUnexpectedStatus.StatusCode >= 500
+	}
+
+	return true
+}
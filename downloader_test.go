@@ -0,0 +1,278 @@
+package httputils_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+type memWriterAt struct {
+	mutex sync.Mutex
+	buf   []byte
+}
+
+func (w *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if end := int(off) + len(p); end > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, end-len(w.buf))...)
+	}
+	copy(w.buf[off:], p)
+
+	return len(p), nil
+}
+
+func (w *memWriterAt) Bytes() []byte {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.buf
+}
+
+var _ = Describe("Downloader", func() {
+	var content []byte
+	var etag string
+	var requests []*http.Request
+	var requestsMutex sync.Mutex
+	var handler http.HandlerFunc
+	var server *httptest.Server
+
+	serveContent := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}
+
+	getRequests := func() []*http.Request {
+		requestsMutex.Lock()
+		defer requestsMutex.Unlock()
+		return requests
+	}
+
+	BeforeEach(func() {
+		content = make([]byte, 1000)
+		for i := range content {
+			content[i] = byte(i % 251)
+		}
+		etag = `"v1"`
+		requests = nil
+		handler = serveContent
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestsMutex.Lock()
+			requests = append(requests, r)
+			requestsMutex.Unlock()
+
+			handler(w, r)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should download a file in a single request", func() {
+		w := &memWriterAt{}
+		res, err := (&Downloader{}).Download(context.Background(), server.URL, w)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal(&DownloadResult{Size: 1000, ETag: `"v1"`, RangesSupported: true}))
+		Expect(w.Bytes()).To(Equal(content))
+
+		Expect(getRequests()).To(HaveLen(1))
+		Expect(getRequests()[0].Header.Get("Range")).To(Equal("bytes=0-"))
+		Expect(getRequests()[0].Header.Get("If-Range")).To(Equal(""))
+	})
+
+	It("should download a file in parallel chunks", func() {
+		w := &memWriterAt{}
+		d := &Downloader{ChunkSize: 300, Concurrency: 3}
+		res, err := d.Download(context.Background(), server.URL, w)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.Size).To(Equal(int64(1000)))
+		Expect(w.Bytes()).To(Equal(content))
+
+		ranges := []string{}
+		for _, r := range getRequests() {
+			ranges = append(ranges, r.Header.Get("Range"))
+			if r.Header.Get("Range") != "bytes=0-299" {
+				Expect(r.Header.Get("If-Range")).To(Equal(`"v1"`))
+			}
+		}
+		Expect(ranges).To(ConsistOf("bytes=0-299", "bytes=300-599", "bytes=600-899", "bytes=900-999"))
+	})
+
+	It("should download an empty file", func() {
+		content = []byte{}
+
+		w := &memWriterAt{}
+		res, err := (&Downloader{ChunkSize: 100}).Download(context.Background(), server.URL, w)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.Size).To(Equal(int64(0)))
+		Expect(w.Bytes()).To(BeEmpty())
+	})
+
+	It("should resume after a failed request", func() {
+		failed := false
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if !failed {
+				failed = true
+				w.Header().Set("ETag", etag)
+				w.Header().Set("Content-Range", "bytes 0-999/1000")
+				w.Header().Set("Content-Length", "1000")
+				w.WriteHeader(http.StatusPartialContent)
+				_, _ = w.Write(content[:400])
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			serveContent(w, r)
+		}
+
+		w := &memWriterAt{}
+		_, err := (&Downloader{MaxRetries: 1}).Download(context.Background(), server.URL, w)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Bytes()).To(Equal(content))
+
+		Expect(getRequests()).To(HaveLen(2))
+		Expect(getRequests()[1].Header.Get("Range")).To(Equal("bytes=400-999"))
+		Expect(getRequests()[1].Header.Get("If-Range")).To(Equal(`"v1"`))
+	})
+
+	It("should count a failed first request against MaxRetries", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Content-Range", "bytes 0-999/1000")
+			w.Header().Set("Content-Length", "1000")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(content[:5])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+
+		_, err := (&Downloader{}).Download(context.Background(), server.URL, &memWriterAt{})
+		Expect(err).To(HaveOccurred())
+		Expect(getRequests()).To(HaveLen(1))
+	})
+
+	It("should resume the first chunk with the remaining retries", func() {
+		failed := false
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if failed {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			failed = true
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Content-Range", "bytes 0-999/1000")
+			w.Header().Set("Content-Length", "1000")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(content[:5])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+
+		_, err := (&Downloader{MaxRetries: 2}).Download(context.Background(), server.URL, &memWriterAt{})
+		Expect(err).To(Equal(NewErrUnexpectedStatus(http.StatusServiceUnavailable)))
+		Expect(getRequests()).To(HaveLen(3))
+		Expect(getRequests()[1].Header.Get("Range")).To(Equal("bytes=5-999"))
+	})
+
+	It("should not retry more than MaxRetries times", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		_, err := (&Downloader{MaxRetries: 2}).Download(context.Background(), server.URL, &memWriterAt{})
+		Expect(err).To(Equal(NewErrUnexpectedStatus(http.StatusServiceUnavailable)))
+		Expect(getRequests()).To(HaveLen(3))
+	})
+
+	It("should not retry client errors", func() {
+		handler = http.NotFound
+
+		_, err := (&Downloader{MaxRetries: 2}).Download(context.Background(), server.URL, &memWriterAt{})
+		var errUnexpectedStatus *ErrUnexpectedStatus
+		Expect(errors.As(err, &errUnexpectedStatus)).To(BeTrue())
+		Expect(errUnexpectedStatus.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(getRequests()).To(HaveLen(1))
+	})
+
+	It("should detect servers that ignore ranges", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			_, _ = w.Write(content)
+		}
+
+		w := &memWriterAt{}
+		res, err := (&Downloader{ChunkSize: 300}).Download(context.Background(), server.URL, w)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal(&DownloadResult{Size: 1000}))
+		Expect(w.Bytes()).To(Equal(content))
+		Expect(getRequests()).To(HaveLen(1))
+
+		_, err = (&Downloader{ChunkSize: 300, RequireRanges: true}).Download(context.Background(), server.URL, &memWriterAt{})
+		Expect(err).To(Equal(ErrRangeNotSupported))
+	})
+
+	It("should fail if the resource changes", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			serveContent(w, r)
+			etag = `"v2"`
+		}
+
+		_, err := (&Downloader{ChunkSize: 300, MaxRetries: 3}).Download(context.Background(), server.URL, &memWriterAt{})
+		Expect(err).To(Equal(ErrDownloadChanged))
+		Expect(getRequests()).To(HaveLen(2))
+	})
+
+	It("should verify the Content-Range of each response", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") == "bytes=0-299" {
+				serveContent(w, r)
+				return
+			}
+			w.Header().Set("Content-Range", "bytes 0-299/1000")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(content[:300])
+		}
+
+		_, err := (&Downloader{ChunkSize: 300, MaxRetries: 3}).Download(context.Background(), server.URL, &memWriterAt{})
+		Expect(err).To(Equal(ErrInvalidContentRange))
+	})
+
+	It("should require a known size for range responses", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Range", "bytes 0-299/*")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(content[:300])
+		}
+
+		_, err := (&Downloader{ChunkSize: 300}).Download(context.Background(), server.URL, &memWriterAt{})
+		Expect(err).To(Equal(ErrDownloadSizeUnknown))
+	})
+
+	It("should stop when the context is canceled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "bytes=0-299" {
+				cancel()
+			}
+			serveContent(w, r)
+		}
+
+		_, err := (&Downloader{ChunkSize: 300, MaxRetries: 3}).Download(ctx, server.URL, &memWriterAt{})
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+	})
+})
+
+var _ io.WriterAt = (*memWriterAt)(nil)
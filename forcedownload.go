@@ -8,6 +8,13 @@ import (
 )
 
 func ForceDownload(filename string, h http.Header) {
+	h.Set("Content-Type", "application/force-download")
+	h.Set("Content-Disposition", contentDisposition("attachment", filename))
+}
+
+// contentDisposition returns a Content-Disposition header value with both the
+// ASCII filename and the UTF-8 filename* parameters.
+func contentDisposition(disposition string, filename string) string {
 	escapedName := strings.Replace(filename, `"`, `\"`, -1)
 	asciiName := asciiEscape(escapedName)
 
@@ -18,10 +25,7 @@ func ForceDownload(filename string, h http.Header) {
 	encodedName := strings.Replace(u.String(), ",", "%2C", -1)
 	encodedName = strings.Replace(encodedName, ";", "%3B", -1)
 
-	d := fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, asciiName, encodedName)
-
-	h.Set("Content-Type", "application/force-download")
-	h.Set("Content-Disposition", d)
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, asciiName, encodedName)
 }
 
 func asciiEscape(s string) string {
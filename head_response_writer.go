@@ -0,0 +1,133 @@
+package httputils
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// HeadAwareResponseWriter lets handlers treat HEAD requests like GET requests.
+// For HEAD requests the body is discarded but still counted, and Done sets
+// Content-Length to the discarded length so that the headers are identical to
+// the ones of a GET request. For other methods writes go straight to the
+// underlying writer, but http.Hijacker is hidden, so HeadAwareHandler only
+// wraps HEAD requests.
+type HeadAwareResponseWriter struct {
+	http.ResponseWriter
+	passThrough
+	Head bool
+	// ResponseLength is the number of body bytes written, including the
+	// discarded ones.
+	ResponseLength int64
+
+	statusCode  int
+	wroteHeader bool
+}
+
+func NewHeadAwareResponseWriter(w http.ResponseWriter, r *http.Request) *HeadAwareResponseWriter {
+	hw := &HeadAwareResponseWriter{
+		ResponseWriter: w,
+		Head:           r.Method == http.MethodHead,
+		ResponseLength: 0,
+
+		statusCode:  http.StatusOK,
+		wroteHeader: false,
+	}
+	hw.passThrough = passThrough{&hw.ResponseWriter}
+
+	return hw
+}
+
+func (w *HeadAwareResponseWriter) WriteHeader(statusCode int) {
+	if !w.Head || (statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols) {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	// the header is written in Done once the body length is known
+	if !w.wroteHeader {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *HeadAwareResponseWriter) Write(b []byte) (int, error) {
+	if !w.Head {
+		n, err := w.ResponseWriter.Write(b)
+		w.ResponseLength += int64(n)
+		return n, err
+	}
+
+	w.ResponseLength += int64(len(b))
+	return len(b), nil
+}
+
+func (w *HeadAwareResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !w.Head {
+		readerFrom, ok := w.ResponseWriter.(io.ReaderFrom)
+		if !ok {
+			return io.Copy(writerOnly{w}, src)
+		}
+		n, err := readerFrom.ReadFrom(src)
+		w.ResponseLength += n
+		return n, err
+	}
+
+	n, err := io.Copy(io.Discard, src)
+	w.ResponseLength += n
+	return n, err
+}
+
+func (w *HeadAwareResponseWriter) Flush() {
+	_ = w.FlushError()
+}
+
+// FlushError writes the header (without Content-Length for HEAD requests, as
+// the response is being streamed) and flushes the underlying writer.
+func (w *HeadAwareResponseWriter) FlushError() error {
+	if w.Head {
+		w.writeHeader()
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Done must be called after the handler returns. For HEAD requests it sets
+// Content-Length (unless already set) and writes the header.
+func (w *HeadAwareResponseWriter) Done() {
+	if !w.Head || w.wroteHeader {
+		return
+	}
+
+	if bodyAllowedForStatus(w.statusCode) && w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(w.ResponseLength, 10))
+	}
+
+	w.writeHeader()
+}
+
+func (w *HeadAwareResponseWriter) writeHeader() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(w.statusCode)
+}
+
+// HeadAwareHandler wraps h so that HEAD requests are served by h with the body
+// discarded. Other requests are served with the original writer.
+func HeadAwareHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		hw := NewHeadAwareResponseWriter(w, r)
+		defer hw.Done()
+
+		h.ServeHTTP(hw, r)
+	})
+}
+
+var _ http.ResponseWriter = (*HeadAwareResponseWriter)(nil)
+var _ http.Flusher = (*HeadAwareResponseWriter)(nil)
+var _ io.ReaderFrom = (*HeadAwareResponseWriter)(nil)
@@ -0,0 +1,91 @@
+package httputils_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+var _ = Describe("HeadAwareResponseWriter", func() {
+	handler := HeadAwareHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ForceDownload("file.txt", w.Header())
+		_, _ = io.Copy(w, struct{ io.Reader }{strings.NewReader("hello world")})
+	}))
+
+	It("should send the same headers for HEAD and GET without a body", func() {
+		getRec := httptest.NewRecorder()
+		handler.ServeHTTP(getRec, httptest.NewRequest("GET", "/", nil))
+
+		headRec := httptest.NewRecorder()
+		handler.ServeHTTP(headRec, httptest.NewRequest("HEAD", "/", nil))
+
+		Expect(getRec.Body.String()).To(Equal("hello world"))
+		Expect(headRec.Code).To(Equal(http.StatusOK))
+		Expect(headRec.Body.Len()).To(Equal(0))
+		Expect(headRec.Header().Get("Content-Length")).To(Equal("11"))
+		Expect(headRec.Header().Get("Content-Disposition")).To(Equal(getRec.Header().Get("Content-Disposition")))
+	})
+
+	It("should count discarded bytes for CaptureResponseWriter", func() {
+		rec := httptest.NewRecorder()
+		hw := NewHeadAwareResponseWriter(rec, httptest.NewRequest("HEAD", "/", nil))
+		cw := NewCaptureResponseWriter(hw)
+
+		cw.WriteHeader(http.StatusPartialContent)
+		_, err := cw.Write([]byte("hello"))
+		Expect(err).NotTo(HaveOccurred())
+		n, err := cw.ReadFrom(strings.NewReader("world"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(int64(5)))
+		hw.Done()
+
+		Expect(cw.ResponseLength).To(Equal(int64(10)))
+		Expect(hw.ResponseLength).To(Equal(int64(10)))
+		Expect(rec.Code).To(Equal(http.StatusPartialContent))
+		Expect(rec.Header().Get("Content-Length")).To(Equal("10"))
+		Expect(rec.Body.Len()).To(Equal(0))
+	})
+
+	It("should keep an explicit Content-Length", func() {
+		rec := httptest.NewRecorder()
+		hw := NewHeadAwareResponseWriter(rec, httptest.NewRequest("HEAD", "/", nil))
+
+		hw.Header().Set("Content-Length", "1000")
+		_, _ = hw.Write([]byte("partial"))
+		hw.Done()
+
+		Expect(rec.Header().Get("Content-Length")).To(Equal("1000"))
+	})
+
+	It("should pass through other methods", func() {
+		rec := httptest.NewRecorder()
+		hw := NewHeadAwareResponseWriter(rec, httptest.NewRequest("GET", "/", nil))
+
+		hw.WriteHeader(http.StatusCreated)
+		_, _ = hw.Write([]byte("hello"))
+		hw.Done()
+
+		Expect(rec.Code).To(Equal(http.StatusCreated))
+		Expect(rec.Body.String()).To(Equal("hello"))
+		Expect(rec.Header().Get("Content-Length")).To(BeEmpty())
+	})
+
+	It("should serve other methods with the original writer", func() {
+		fw := newFullResponseWriter()
+
+		var served http.ResponseWriter
+		HeadAwareHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = w
+		})).ServeHTTP(fw, httptest.NewRequest("GET", "/", nil))
+
+		Expect(served).To(BeIdenticalTo(fw))
+		_, ok := served.(http.Hijacker)
+		Expect(ok).To(BeTrue())
+	})
+})
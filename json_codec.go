@@ -0,0 +1,55 @@
+package httputils
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type JSONEncoder interface {
+	Encode(v interface{}) error
+	SetEscapeHTML(on bool)
+	SetIndent(prefix, indent string)
+}
+
+type JSONDecoder interface {
+	Decode(v interface{}) error
+}
+
+// JSONCodec is used by the request and response JSON helpers. It can be
+// replaced globally with DefaultJSONCodec or per call with the Codec options.
+type JSONCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	NewEncoder(w io.Writer) JSONEncoder
+	NewDecoder(r io.Reader) JSONDecoder
+}
+
+// StdJSONCodec is a JSONCodec backed by encoding/json.
+type StdJSONCodec struct{}
+
+func (StdJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (StdJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (StdJSONCodec) NewEncoder(w io.Writer) JSONEncoder {
+	return json.NewEncoder(w)
+}
+
+func (StdJSONCodec) NewDecoder(r io.Reader) JSONDecoder {
+	return json.NewDecoder(r)
+}
+
+var DefaultJSONCodec JSONCodec = StdJSONCodec{}
+
+func jsonCodecOrDefault(codec JSONCodec) JSONCodec {
+	if codec != nil {
+		return codec
+	}
+	return DefaultJSONCodec
+}
+
+var _ JSONCodec = StdJSONCodec{}
@@ -0,0 +1,105 @@
+package httputils_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+type countingJSONCodec struct {
+	StdJSONCodec
+	unmarshals int
+	encoders   int
+}
+
+func (c *countingJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	c.unmarshals++
+	return c.StdJSONCodec.Unmarshal(data, v)
+}
+
+func (c *countingJSONCodec) NewEncoder(w io.Writer) JSONEncoder {
+	c.encoders++
+	return c.StdJSONCodec.NewEncoder(w)
+}
+
+var _ = Describe("JSONCodec", func() {
+	It("should use the per-call codec", func() {
+		codec := &countingJSONCodec{}
+
+		v := map[string]interface{}{}
+		r := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"foo":"bar"}`)))
+		r.Header.Set("Content-Type", "application/json")
+		_, err := RequestJSONErrorWithOptions(r, &v, &RequestJSONOptions{MaxSize: 1024, Codec: codec})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v).To(Equal(map[string]interface{}{"foo": "bar"}))
+
+		w := httptest.NewRecorder()
+		Expect(ResponseJSONWithOptions(w, r, 200, v, &ResponseJSONOptions{Codec: codec})).To(Succeed())
+		Expect(w.Body.String()).To(Equal(`{"foo":"bar"}`))
+
+		Expect(codec.unmarshals).To(Equal(1))
+		Expect(codec.encoders).To(Equal(1))
+	})
+
+	It("should use the package-level codec", func() {
+		codec := &countingJSONCodec{}
+		defaultCodec := DefaultJSONCodec
+		DefaultJSONCodec = codec
+		defer func() {
+			DefaultJSONCodec = defaultCodec
+		}()
+
+		w := httptest.NewRecorder()
+		Expect(ResponseJSON(w, httptest.NewRequest("GET", "/", nil), 200, []int{1})).To(Succeed())
+
+		Expect(codec.encoders).To(Equal(1))
+	})
+})
+
+var benchmarkJSONValue = map[string]interface{}{
+	"id":    "8f7a6b5c-4d3e-2f1a-0b9c-8d7e6f5a4b3c",
+	"name":  "file.txt",
+	"size":  123456,
+	"tags":  []string{"a", "b", "c"},
+	"owner": map[string]interface{}{"id": 1, "name": "user"},
+}
+
+func BenchmarkRequestJSONError(b *testing.B) {
+	body, err := json.Marshal(benchmarkJSONValue)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(body)))
+
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+
+		var v map[string]interface{}
+		if _, err := RequestJSONError(r, &v, 1024*1024); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkResponseJSON(b *testing.B) {
+	r := httptest.NewRequest("GET", "/", nil)
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		if err := ResponseJSON(w, r, 200, benchmarkJSONValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
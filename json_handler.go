@@ -0,0 +1,121 @@
+package httputils
+
+import (
+	"context"
+	"net/http"
+)
+
+// StatusCoder can be implemented by handler errors to choose the response
+// status code.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+type HTTPError struct {
+	Status int
+	Err    error
+}
+
+func NewHTTPError(status int, err error) *HTTPError {
+	return &HTTPError{
+		Status: status,
+		Err:    err,
+	}
+}
+
+func (e *HTTPError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HTTPError) StatusCode() int {
+	return e.Status
+}
+
+// DecodeJSON decodes and validates the JSON request body into a new T.
+func DecodeJSON[T any](r *http.Request, opts *RequestJSONOptions) (T, error) {
+	var v T
+
+	if _, err := RequestJSONErrorWithOptions(r, &v, opts); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	// RequestJSONErrorWithOptions only validates *T
+	if _, ok := any(&v).(Validator); !ok {
+		if err := validate(any(v)); err != nil {
+			var zero T
+			return zero, NewErrRequestJSON(err)
+		}
+	}
+
+	return v, nil
+}
+
+type JSONHandlerOptions struct {
+	Request  *RequestJSONOptions
+	Response *ResponseJSONOptions
+	// SuccessStatus defaults to 200. No body is written for 204.
+	SuccessStatus int
+	// ErrorStatus maps errors to status codes. Defaults to ErrorStatusCode.
+	ErrorStatus func(err error) int
+	// ErrorResponse writes error responses. Defaults to ResponseError.
+	ErrorResponse func(w http.ResponseWriter, r *http.Request, statusCode int, err error)
+}
+
+// JSONHandler returns a handler which decodes the request body into Req,
+// calls fn and writes the result as JSON. If Req is struct{}, the request
+// body is not decoded.
+func JSONHandler[Req any, Resp any](fn func(ctx context.Context, req Req) (Resp, error)) http.Handler {
+	return JSONHandlerWithOptions(fn, nil)
+}
+
+func JSONHandlerWithOptions[Req any, Resp any](fn func(ctx context.Context, req Req) (Resp, error), opts *JSONHandlerOptions) http.Handler {
+	if opts == nil {
+		opts = &JSONHandlerOptions{}
+	}
+
+	successStatus := opts.SuccessStatus
+	if successStatus == 0 {
+		successStatus = http.StatusOK
+	}
+	errorStatus := opts.ErrorStatus
+	if errorStatus == nil {
+		errorStatus = ErrorStatusCode
+	}
+	errorResponse := opts.ErrorResponse
+	if errorResponse == nil {
+		errorResponse = func(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+			_ = ResponseError(w, r, statusCode, err)
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+
+		if _, noBody := any(req).(struct{}); !noBody {
+			var err error
+			req, err = DecodeJSON[Req](r, opts.Request)
+			if err != nil {
+				errorResponse(w, r, errorStatus(err), err)
+				return
+			}
+		}
+
+		resp, err := fn(r.Context(), req)
+		if err != nil {
+			errorResponse(w, r, errorStatus(err), err)
+			return
+		}
+
+		if successStatus == http.StatusNoContent {
+			w.WriteHeader(successStatus)
+			return
+		}
+
+		_ = ResponseJSONWithOptions(w, r, successStatus, resp, opts.Response)
+	})
+}
@@ -0,0 +1,134 @@
+package httputils_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+type greeting struct {
+	Message string `json:"message"`
+}
+
+var _ = Describe("DecodeJSON", func() {
+	It("should decode into a value", func() {
+		v, err := DecodeJSON[validatedRequest](newJSONRequest(`{"name": "x", "limit": 2}`), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v).To(Equal(validatedRequest{Name: "x", Limit: 2}))
+	})
+
+	It("should decode into a pointer and validate it", func() {
+		v, err := DecodeJSON[*validatedRequest](newJSONRequest(`{"limit": 1}`), nil)
+		Expect(err).To(HaveOccurred())
+		Expect(v).To(BeNil())
+		Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("should return a zero value on error", func() {
+		v, err := DecodeJSON[validatedRequest](newJSONRequest(`{"name": "x", "limit": -1}`), nil)
+		Expect(err).To(HaveOccurred())
+		Expect(v).To(Equal(validatedRequest{}))
+	})
+
+	It("should respect options", func() {
+		_, err := DecodeJSON[validatedRequest](newJSONRequest(`{"name": "x"}`), &RequestJSONOptions{MaxSize: 4})
+		Expect(errors.Is(err, ErrRequestBodyTooLarge)).To(BeTrue())
+	})
+
+	It("should use the default size limit if MaxSize is not set", func() {
+		v, err := DecodeJSON[validatedRequest](newJSONRequest(`{"name": "x"}`), &RequestJSONOptions{Codec: DefaultJSONCodec})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v.Name).To(Equal("x"))
+	})
+})
+
+var _ = Describe("JSONHandler", func() {
+	greet := func(ctx context.Context, req *validatedRequest) (*greeting, error) {
+		if req.Name == "teapot" {
+			return nil, NewHTTPError(http.StatusTeapot, errors.New("i am a teapot"))
+		}
+		if req.Name == "fail" {
+			return nil, errors.New("secret database error")
+		}
+		return &greeting{Message: "hello " + req.Name}, nil
+	}
+
+	It("should decode the request and encode the response", func() {
+		w := httptest.NewRecorder()
+		JSONHandler(greet).ServeHTTP(w, newJSONRequest(`{"name": "world"}`))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/json; charset=utf-8"))
+		Expect(w.Body.String()).To(Equal(`{"message":"hello world"}`))
+	})
+
+	It("should respond with a problem for request errors", func() {
+		w := httptest.NewRecorder()
+		JSONHandler(greet).ServeHTTP(w, newJSONRequest(`{"limit": 1}`))
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(w.Header().Get("Content-Type")).To(Equal(ProblemContentType))
+		Expect(w.Body.String()).To(ContainSubstring(`"field":"name"`))
+	})
+
+	It("should use the status code of StatusCoder errors", func() {
+		w := httptest.NewRecorder()
+		JSONHandler(greet).ServeHTTP(w, newJSONRequest(`{"name": "teapot"}`))
+		Expect(w.Code).To(Equal(http.StatusTeapot))
+		Expect(w.Body.String()).To(ContainSubstring(`"detail":"i am a teapot"`))
+	})
+
+	It("should hide the details of internal errors", func() {
+		w := httptest.NewRecorder()
+		JSONHandler(greet).ServeHTTP(w, newJSONRequest(`{"name": "fail"}`))
+		Expect(w.Code).To(Equal(http.StatusInternalServerError))
+		Expect(w.Body.String()).NotTo(ContainSubstring("secret"))
+	})
+
+	It("should not decode the body for struct{} requests", func() {
+		handler := JSONHandler(func(ctx context.Context, req struct{}) ([]int, error) {
+			return []int{1, 2}, nil
+		})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal(`[1,2]`))
+	})
+
+	It("should support custom options", func() {
+		var gotErr error
+		handler := JSONHandlerWithOptions(greet, &JSONHandlerOptions{
+			SuccessStatus: http.StatusCreated,
+			Response:      &ResponseJSONOptions{Pretty: true},
+			ErrorStatus: func(err error) int {
+				return http.StatusServiceUnavailable
+			},
+			ErrorResponse: func(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+				gotErr = err
+				w.WriteHeader(statusCode)
+			},
+		})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newJSONRequest(`{"name": "world"}`))
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(w.Body.String()).To(Equal("{\n  \"message\": \"hello world\"\n}"))
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, newJSONRequest(`{"name": "fail"}`))
+		Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(gotErr).To(MatchError("secret database error"))
+	})
+
+	It("should write no body for 204", func() {
+		handler := JSONHandlerWithOptions(greet, &JSONHandlerOptions{SuccessStatus: http.StatusNoContent})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newJSONRequest(`{"name": "world"}`))
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(w.Body.Len()).To(Equal(0))
+	})
+})
@@ -0,0 +1,542 @@
+package httputils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// JSONPatchOperation is an RFC 6902 JSON Patch operation. Value is nil if the
+// value member is absent.
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type ErrInvalidJSONPatch struct {
+	Index int
+	Err   error
+}
+
+func NewErrInvalidJSONPatch(index int, err error) *ErrInvalidJSONPatch {
+	return &ErrInvalidJSONPatch{
+		Index: index,
+		Err:   err,
+	}
+}
+
+func (e *ErrInvalidJSONPatch) Error() string {
+	return fmt.Sprintf("invalid json patch operation %d: %s", e.Index, e.Err.Error())
+}
+
+func (e *ErrInvalidJSONPatch) Unwrap() error {
+	return e.Err
+}
+
+type ErrJSONPatchInvalidPath struct {
+	Index int
+	Op    string
+	Path  string
+}
+
+func NewErrJSONPatchInvalidPath(index int, op string, path string) *ErrJSONPatchInvalidPath {
+	return &ErrJSONPatchInvalidPath{
+		Index: index,
+		Op:    op,
+		Path:  path,
+	}
+}
+
+func (e *ErrJSONPatchInvalidPath) Error() string {
+	return fmt.Sprintf("json patch operation %d (%s): invalid path: %q", e.Index, e.Op, e.Path)
+}
+
+type ErrJSONPatchTestFailed struct {
+	Index int
+	Path  string
+}
+
+func NewErrJSONPatchTestFailed(index int, path string) *ErrJSONPatchTestFailed {
+	return &ErrJSONPatchTestFailed{
+		Index: index,
+		Path:  path,
+	}
+}
+
+func (e *ErrJSONPatchTestFailed) Error() string {
+	return fmt.Sprintf("json patch operation %d (test): test failed: %q", e.Index, e.Path)
+}
+
+// RequestMergePatch decodes an RFC 7396 JSON Merge Patch request body and
+// applies it to v, which must be a pointer to the current document.
+func RequestMergePatch(r *http.Request, v interface{}, opts *RequestJSONOptions) error {
+	opts = patchRequestOptions(opts, MergePatchContentType)
+
+	var patch json.RawMessage
+	if _, err := RequestJSONErrorWithOptions(r, &patch, opts); err != nil {
+		return err
+	}
+
+	return applyPatchToValue(v, opts, func(doc []byte) ([]byte, error) {
+		return ApplyMergePatch(doc, patch)
+	})
+}
+
+// RequestJSONPatch decodes an RFC 6902 JSON Patch request body and applies it
+// to v, which must be a pointer to the current document.
+func RequestJSONPatch(r *http.Request, v interface{}, opts *RequestJSONOptions) error {
+	opts = patchRequestOptions(opts, JSONPatchContentType)
+
+	var patch []JSONPatchOperation
+	if _, err := RequestJSONErrorWithOptions(r, &patch, opts); err != nil {
+		return err
+	}
+
+	return applyPatchToValue(v, opts, func(doc []byte) ([]byte, error) {
+		return ApplyJSONPatch(doc, patch)
+	})
+}
+
+func patchRequestOptions(opts *RequestJSONOptions, contentType string) *RequestJSONOptions {
+	patchOpts := RequestJSONOptions{MaxSize: DefaultMaxRequestJSONSize}
+	if opts != nil {
+		patchOpts = *opts
+	}
+	if patchOpts.ContentTypePolicy == nil {
+		patchOpts.ContentTypePolicy = &ContentTypePolicy{
+			Allowed: []string{contentType},
+		}
+	}
+	return &patchOpts
+}
+
+func applyPatchToValue(v interface{}, opts *RequestJSONOptions, apply func(doc []byte) ([]byte, error)) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("patch target must be a non-nil pointer, got %T", v)
+	}
+
+	codec := jsonCodecOrDefault(opts.Codec)
+
+	doc, err := codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("patch marshal error: %w", err)
+	}
+
+	patched, err := apply(doc)
+	if err != nil {
+		return NewErrRequestJSON(err)
+	}
+
+	// decode into a zero value so that removed fields are cleared
+	result := reflect.New(rv.Elem().Type())
+	if err := codec.Unmarshal(patched, result.Interface()); err != nil {
+		return NewErrRequestJSON(NewErrInvalidJSON(err, patched))
+	}
+	rv.Elem().Set(result.Elem())
+
+	if err := validate(v); err != nil {
+		return NewErrRequestJSON(err)
+	}
+
+	return nil
+}
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch to doc.
+func ApplyMergePatch(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decodeJSONDocument(doc)
+	if err != nil {
+		return nil, NewErrInvalidJSON(err, doc)
+	}
+	patchValue, err := decodeJSONDocument(patch)
+	if err != nil {
+		return nil, NewErrInvalidJSON(err, patch)
+	}
+
+	return json.Marshal(mergePatch(target, patchValue))
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+
+	return targetObject
+}
+
+// ApplyJSONPatch applies RFC 6902 JSON Patch operations to doc. Operations are
+// applied atomically: if any operation fails, an error is returned.
+func ApplyJSONPatch(doc []byte, patch []JSONPatchOperation) ([]byte, error) {
+	target, err := decodeJSONDocument(doc)
+	if err != nil {
+		return nil, NewErrInvalidJSON(err, doc)
+	}
+
+	for i, op := range patch {
+		target, err = applyJSONPatchOperation(target, i, op)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+var errJSONPointer = errors.New("invalid json pointer")
+
+func applyJSONPatchOperation(doc interface{}, index int, op JSONPatchOperation) (interface{}, error) {
+	path, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return nil, NewErrJSONPatchInvalidPath(index, op.Op, op.Path)
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, NewErrInvalidJSONPatch(index, fmt.Errorf("missing value for %s", op.Op))
+		}
+		value, err = decodeJSONDocument(op.Value)
+		if err != nil {
+			return nil, NewErrInvalidJSONPatch(index, err)
+		}
+	}
+
+	var from []string
+	switch op.Op {
+	case "move", "copy":
+		from, err = parseJSONPointer(op.From)
+		if err != nil {
+			return nil, NewErrJSONPatchInvalidPath(index, op.Op, op.From)
+		}
+	}
+
+	invalidPath := func(path string) error {
+		return NewErrJSONPatchInvalidPath(index, op.Op, path)
+	}
+
+	switch op.Op {
+	case "add":
+		doc, err = jsonPointerAdd(doc, path, value)
+		if err != nil {
+			return nil, invalidPath(op.Path)
+		}
+	case "remove":
+		doc, _, err = jsonPointerRemove(doc, path)
+		if err != nil {
+			return nil, invalidPath(op.Path)
+		}
+	case "replace":
+		if _, err := jsonPointerGet(doc, path); err != nil {
+			return nil, invalidPath(op.Path)
+		}
+		doc, err = jsonPointerSet(doc, path, value)
+		if err != nil {
+			return nil, invalidPath(op.Path)
+		}
+	case "move":
+		if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, invalidPath(op.Path)
+		}
+		doc, value, err = jsonPointerRemove(doc, from)
+		if err != nil {
+			return nil, invalidPath(op.From)
+		}
+		doc, err = jsonPointerAdd(doc, path, value)
+		if err != nil {
+			return nil, invalidPath(op.Path)
+		}
+	case "copy":
+		value, err = jsonPointerGet(doc, from)
+		if err != nil {
+			return nil, invalidPath(op.From)
+		}
+		doc, err = jsonPointerAdd(doc, path, copyJSONValue(value))
+		if err != nil {
+			return nil, invalidPath(op.Path)
+		}
+	case "test":
+		current, err := jsonPointerGet(doc, path)
+		if err != nil || !jsonValuesEqual(current, value) {
+			return nil, NewErrJSONPatchTestFailed(index, op.Path)
+		}
+	default:
+		return nil, NewErrInvalidJSONPatch(index, fmt.Errorf("unknown op: %q", op.Op))
+	}
+
+	return doc, nil
+}
+
+func decodeJSONDocument(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after top-level value")
+	}
+
+	return v, nil
+}
+
+// parseJSONPointer parses an RFC 6901 JSON Pointer into reference tokens.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, errJSONPointer
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		for j := 0; j < len(token); j++ {
+			if token[j] == '~' && (j+1 == len(token) || (token[j+1] != '0' && token[j+1] != '1')) {
+				return nil, errJSONPointer
+			}
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func jsonPointerIndex(token string, length int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, errJSONPointer
+	}
+	for _, c := range token {
+		if c < '0' || c > '9' {
+			return 0, errJSONPointer
+		}
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index >= length {
+		return 0, errJSONPointer
+	}
+	return index, nil
+}
+
+func jsonPointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			child, ok := node[token]
+			if !ok {
+				return nil, errJSONPointer
+			}
+			doc = child
+		case []interface{}:
+			index, err := jsonPointerIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			doc = node[index]
+		default:
+			return nil, errJSONPointer
+		}
+	}
+	return doc, nil
+}
+
+// jsonPointerUpdate replaces the value at path (which must exist, except for
+// the last token which is handled by update) and returns the new document.
+func jsonPointerUpdate(doc interface{}, path []string, update func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return update(doc, path[0])
+	}
+
+	token := path[0]
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[token]
+		if !ok {
+			return nil, errJSONPointer
+		}
+		child, err := jsonPointerUpdate(child, path[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = child
+		return node, nil
+	case []interface{}:
+		index, err := jsonPointerIndex(token, len(node))
+		if err != nil {
+			return nil, err
+		}
+		child, err := jsonPointerUpdate(node[index], path[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = child
+		return node, nil
+	}
+
+	return nil, errJSONPointer
+}
+
+func jsonPointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return jsonPointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			if token == "-" {
+				return append(node, value), nil
+			}
+			index, err := jsonPointerIndex(token, len(node)+1)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		return nil, errJSONPointer
+	})
+}
+
+func jsonPointerSet(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return jsonPointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			index, err := jsonPointerIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			node[index] = value
+			return node, nil
+		}
+		return nil, errJSONPointer
+	})
+}
+
+func jsonPointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, errJSONPointer
+	}
+
+	var removed interface{}
+
+	doc, err := jsonPointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, errJSONPointer
+			}
+			removed = value
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			index, err := jsonPointerIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			removed = node[index]
+			return append(node[:index:index], node[index+1:]...), nil
+		}
+		return nil, errJSONPointer
+	})
+
+	return doc, removed, err
+}
+
+func copyJSONValue(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(node))
+		for key, value := range node {
+			c[key] = copyJSONValue(value)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(node))
+		for i, value := range node {
+			c[i] = copyJSONValue(value)
+		}
+		return c
+	}
+	return v
+}
+
+func jsonValuesEqual(a interface{}, b interface{}) bool {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			other, ok := b[key]
+			if !ok || !jsonValuesEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonValuesEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, okA := new(big.Rat).SetString(a.String())
+		y, okB := new(big.Rat).SetString(b.String())
+		return okA && okB && x.Cmp(y) == 0
+	}
+	return a == b
+}
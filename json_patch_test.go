@@ -0,0 +1,224 @@
+package httputils_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+type patchDocument struct {
+	Name  string   `json:"name"`
+	Tags  []string `json:"tags,omitempty"`
+	Limit *int     `json:"limit,omitempty"`
+}
+
+func (d *patchDocument) Validate() error {
+	if d.Name == "" {
+		return ValidationErrors{NewValidationError("name", "required", "name is required")}
+	}
+	return nil
+}
+
+var _ = Describe("JSONPatch", func() {
+	Describe("ApplyMergePatch", func() {
+		DescribeTable("RFC 7396 examples",
+			func(doc string, patch string, expected string) {
+				result, err := ApplyMergePatch([]byte(doc), []byte(patch))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(MatchJSON(expected))
+			},
+			Entry(nil, `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`),
+			Entry(nil, `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`),
+			Entry(nil, `{"a":"b"}`, `{"a":null}`, `{}`),
+			Entry(nil, `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`),
+			Entry(nil, `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`),
+			Entry(nil, `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`),
+			Entry(nil, `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`),
+			Entry(nil, `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`),
+			Entry(nil, `["a","b"]`, `["c","d"]`, `["c","d"]`),
+			Entry(nil, `{"a":"b"}`, `["c"]`, `["c"]`),
+			Entry(nil, `{"a":"foo"}`, `null`, `null`),
+			Entry(nil, `{"a":"foo"}`, `"bar"`, `"bar"`),
+			Entry(nil, `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`),
+			Entry(nil, `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`),
+			Entry(nil, `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`),
+		)
+
+		It("should preserve large numbers", func() {
+			result, err := ApplyMergePatch([]byte(`{"id":9007199254740993}`), []byte(`{"a":1}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(result)).To(Equal(`{"a":1,"id":9007199254740993}`))
+		})
+	})
+
+	Describe("ApplyJSONPatch", func() {
+		apply := func(doc string, patch string) (string, error) {
+			var ops []JSONPatchOperation
+			Expect(json.Unmarshal([]byte(patch), &ops)).To(Succeed())
+			result, err := ApplyJSONPatch([]byte(doc), ops)
+			return string(result), err
+		}
+
+		DescribeTable("RFC 6902 examples",
+			func(doc string, patch string, expected string) {
+				result, err := apply(doc, patch)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(MatchJSON(expected))
+			},
+			Entry("add object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`),
+			Entry("add array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`),
+			Entry("add to end of array", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`),
+			Entry("add null value", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":null}]`, `{"baz":null,"foo":"bar"}`),
+			Entry("remove object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`),
+			Entry("remove array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`),
+			Entry("replace value", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`),
+			Entry("move value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`),
+			Entry("move array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`),
+			Entry("copy value", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"replace","path":"/baz/bar","value":2}]`, `{"foo":{"bar":1},"baz":{"bar":2}}`),
+			Entry("test value", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`, `{"baz":"qux","foo":["a",2,"c"]}`),
+			Entry("escaped pointers", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"replace","path":"/~1","value":1}]`, `{"/":1,"~1":10}`),
+			Entry("replace root", `{"foo":"bar"}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`),
+		)
+
+		It("should report failed tests", func() {
+			_, err := apply(`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`)
+			Expect(err).To(HaveOccurred())
+			var errTestFailed *ErrJSONPatchTestFailed
+			Expect(errors.As(err, &errTestFailed)).To(BeTrue())
+			Expect(errTestFailed.Index).To(Equal(0))
+			Expect(errTestFailed.Path).To(Equal("/baz"))
+			Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusConflict))
+		})
+
+		DescribeTable("should report invalid paths",
+			func(doc string, patch string, expectedPath string) {
+				_, err := apply(doc, patch)
+				Expect(err).To(HaveOccurred())
+				var errInvalidPath *ErrJSONPatchInvalidPath
+				Expect(errors.As(err, &errInvalidPath)).To(BeTrue())
+				Expect(errInvalidPath.Path).To(Equal(expectedPath))
+				Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+			},
+			Entry("missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, "/baz/bat"),
+			Entry("out of bounds index", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":"qux"}]`, "/foo/2"),
+			Entry("leading zero index", `{"foo":["bar","baz"]}`, `[{"op":"remove","path":"/foo/01"}]`, "/foo/01"),
+			Entry("remove missing member", `{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, "/baz"),
+			Entry("replace missing member", `{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`, "/baz"),
+			Entry("move into own child", `{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`, "/foo/bar/baz"),
+			Entry("invalid pointer", `{"foo":"bar"}`, `[{"op":"add","path":"foo","value":1}]`, "foo"),
+			Entry("invalid escape", `{"foo":"bar"}`, `[{"op":"add","path":"/~2","value":1}]`, "/~2"),
+		)
+
+		It("should reject unknown operations and missing values", func() {
+			_, err := apply(`{}`, `[{"op":"add","path":"/a","value":1},{"op":"merge","path":"/a"}]`)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`invalid json patch operation 1: unknown op: "merge"`))
+			Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusBadRequest))
+
+			_, err = apply(`{}`, `[{"op":"add","path":"/a"}]`)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`invalid json patch operation 0: missing value for add`))
+		})
+	})
+
+	Describe("RequestMergePatch", func() {
+		It("should apply the patch to the current document", func() {
+			limit := 10
+			doc := &patchDocument{Name: "foo", Tags: []string{"a"}, Limit: &limit}
+
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(`{"tags":["b","c"],"limit":null}`))
+			r.Header.Set("Content-Type", MergePatchContentType)
+
+			Expect(RequestMergePatch(r, doc, &RequestJSONOptions{MaxSize: 1024})).To(Succeed())
+			Expect(doc).To(Equal(&patchDocument{Name: "foo", Tags: []string{"b", "c"}}))
+		})
+
+		It("should use the default options", func() {
+			doc := &patchDocument{Name: "foo"}
+
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(`{"name":"bar"}`))
+			r.Header.Set("Content-Type", MergePatchContentType)
+
+			Expect(RequestMergePatch(r, doc, nil)).To(Succeed())
+			Expect(doc).To(Equal(&patchDocument{Name: "bar"}))
+		})
+
+		It("should validate the patched document", func() {
+			doc := &patchDocument{Name: "foo"}
+
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(`{"name":null}`))
+			r.Header.Set("Content-Type", MergePatchContentType)
+
+			err := RequestMergePatch(r, doc, &RequestJSONOptions{MaxSize: 1024})
+			Expect(err).To(HaveOccurred())
+			Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+		})
+
+		It("should require the merge patch content type", func() {
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(`{}`))
+			r.Header.Set("Content-Type", "application/json")
+
+			err := RequestMergePatch(r, &patchDocument{Name: "foo"}, &RequestJSONOptions{MaxSize: 1024})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("request json error: invalid content type: expected Content-Type to be application/merge-patch+json but got: application/json"))
+		})
+
+		It("should apply the size limit", func() {
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(`{"name":"bar"}`))
+			r.Header.Set("Content-Type", MergePatchContentType)
+
+			err := RequestMergePatch(r, &patchDocument{Name: "foo"}, &RequestJSONOptions{MaxSize: 4})
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrRequestBodyTooLarge)).To(BeTrue())
+		})
+	})
+
+	Describe("RequestJSONPatch", func() {
+		It("should apply the patch to the current document", func() {
+			doc := &patchDocument{Name: "foo", Tags: []string{"a"}}
+
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(`[
+				{"op": "test", "path": "/name", "value": "foo"},
+				{"op": "replace", "path": "/name", "value": "bar"},
+				{"op": "add", "path": "/tags/-", "value": "b"}
+			]`))
+			r.Header.Set("Content-Type", JSONPatchContentType)
+
+			Expect(RequestJSONPatch(r, doc, &RequestJSONOptions{MaxSize: 1024})).To(Succeed())
+			Expect(doc).To(Equal(&patchDocument{Name: "bar", Tags: []string{"a", "b"}}))
+		})
+
+		It("should use the default options", func() {
+			doc := &patchDocument{Name: "foo"}
+
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(`[{"op": "replace", "path": "/name", "value": "bar"}]`))
+			r.Header.Set("Content-Type", JSONPatchContentType)
+
+			Expect(RequestJSONPatch(r, doc, nil)).To(Succeed())
+			Expect(doc).To(Equal(&patchDocument{Name: "bar"}))
+		})
+
+		It("should not modify the document if the patch fails", func() {
+			doc := &patchDocument{Name: "foo"}
+
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(`[
+				{"op": "replace", "path": "/name", "value": "bar"},
+				{"op": "test", "path": "/name", "value": "foo"}
+			]`))
+			r.Header.Set("Content-Type", JSONPatchContentType)
+
+			err := RequestJSONPatch(r, doc, &RequestJSONOptions{MaxSize: 1024})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`request json error: json patch operation 1 (test): test failed: "/name"`))
+			Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusConflict))
+			Expect(doc).To(Equal(&patchDocument{Name: "foo"}))
+		})
+	})
+})
@@ -0,0 +1,280 @@
+package httputils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var DefaultMetricsDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var DefaultMetricsSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000, 100000000}
+
+const MetricsOtherRoute = "other"
+
+// MetricsSink receives request observations from MetricsMiddleware.
+type MetricsSink interface {
+	RequestStarted(route string, method string)
+	RequestFinished(route string, method string, statusCode int, duration time.Duration, responseLength int64)
+}
+
+// RouteLabelFunc maps a request to a route label. It should return a small,
+// fixed set of values (e.g. route patterns, not raw paths) so that metric
+// cardinality stays bounded.
+type RouteLabelFunc func(r *http.Request) string
+
+func MetricsMiddleware(sink MetricsSink, routeLabel RouteLabelFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := MetricsOtherRoute
+			if routeLabel != nil {
+				if label := routeLabel(r); label != "" {
+					route = label
+				}
+			}
+			method := metricsMethod(r.Method)
+
+			sink.RequestStarted(route, method)
+
+			cw := NewCaptureResponseWriter(w)
+
+			defer func() {
+				statusCode := cw.StatusCode
+				if cw.Hijacked {
+					statusCode = http.StatusSwitchingProtocols
+				}
+				sink.RequestFinished(route, method, statusCode, cw.Duration(), cw.ResponseLength)
+			}()
+
+			next.ServeHTTP(cw.Wrap(), r)
+		})
+	}
+}
+
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+type MetricsOptions struct {
+	// Namespace is prepended to metric names, e.g. "myapp" results in
+	// "myapp_http_requests_total".
+	Namespace       string
+	DurationBuckets []float64
+	SizeBuckets     []float64
+}
+
+// Metrics is an in-memory MetricsSink which serves the collected metrics in
+// the Prometheus text exposition format.
+type Metrics struct {
+	namespace       string
+	durationBuckets []float64
+	sizeBuckets     []float64
+
+	mu        sync.Mutex
+	requests  map[metricsRequestKey]uint64
+	inFlight  map[string]int64
+	durations map[metricsRouteKey]*metricsHistogram
+	sizes     map[metricsRouteKey]*metricsHistogram
+}
+
+type metricsRouteKey struct {
+	route  string
+	method string
+}
+
+type metricsRequestKey struct {
+	route  string
+	method string
+	code   int
+}
+
+type metricsHistogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func NewMetrics(opts *MetricsOptions) *Metrics {
+	if opts == nil {
+		opts = &MetricsOptions{}
+	}
+
+	durationBuckets := opts.DurationBuckets
+	if durationBuckets == nil {
+		durationBuckets = DefaultMetricsDurationBuckets
+	}
+	sizeBuckets := opts.SizeBuckets
+	if sizeBuckets == nil {
+		sizeBuckets = DefaultMetricsSizeBuckets
+	}
+
+	return &Metrics{
+		namespace:       opts.Namespace,
+		durationBuckets: sortedBuckets(durationBuckets),
+		sizeBuckets:     sortedBuckets(sizeBuckets),
+
+		requests:  map[metricsRequestKey]uint64{},
+		inFlight:  map[string]int64{},
+		durations: map[metricsRouteKey]*metricsHistogram{},
+		sizes:     map[metricsRouteKey]*metricsHistogram{},
+	}
+}
+
+func sortedBuckets(buckets []float64) []float64 {
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+func (m *Metrics) RequestStarted(route string, method string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight[route]++
+}
+
+func (m *Metrics) RequestFinished(route string, method string, statusCode int, duration time.Duration, responseLength int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight[route]--
+
+	m.requests[metricsRequestKey{route: route, method: method, code: statusCode}]++
+
+	key := metricsRouteKey{route: route, method: method}
+
+	durations, ok := m.durations[key]
+	if !ok {
+		durations = &metricsHistogram{counts: make([]uint64, len(m.durationBuckets))}
+		m.durations[key] = durations
+	}
+	durations.observe(m.durationBuckets, duration.Seconds())
+
+	sizes, ok := m.sizes[key]
+	if !ok {
+		sizes = &metricsHistogram{counts: make([]uint64, len(m.sizeBuckets))}
+		m.sizes[key] = sizes
+	}
+	sizes.observe(m.sizeBuckets, float64(responseLength))
+}
+
+func (h *metricsHistogram) observe(buckets []float64, v float64) {
+	for i, le := range buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (m *Metrics) name(name string) string {
+	if m.namespace == "" {
+		return name
+	}
+	return m.namespace + "_" + name
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	name := m.name("http_requests_total")
+	fmt.Fprintf(&b, "# HELP %s Total number of HTTP requests.\n", name)
+	fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+	requestKeys := make([]metricsRequestKey, 0, len(m.requests))
+	for key := range m.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	for _, key := range requestKeys {
+		fmt.Fprintf(&b, "%s{route=%s,method=%s,code=\"%d\"} %d\n", name, metricsLabelValue(key.route), metricsLabelValue(key.method), key.code, m.requests[key])
+	}
+
+	name = m.name("http_requests_in_flight")
+	fmt.Fprintf(&b, "# HELP %s Number of HTTP requests currently being served.\n", name)
+	fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+	routes := make([]string, 0, len(m.inFlight))
+	for route := range m.inFlight {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		fmt.Fprintf(&b, "%s{route=%s} %d\n", name, metricsLabelValue(route), m.inFlight[route])
+	}
+
+	m.writeHistograms(&b, m.name("http_request_duration_seconds"), "HTTP request duration in seconds.", m.durationBuckets, m.durations)
+	m.writeHistograms(&b, m.name("http_response_size_bytes"), "HTTP response size in bytes.", m.sizeBuckets, m.sizes)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *Metrics) writeHistograms(b *strings.Builder, name string, help string, buckets []float64, histograms map[metricsRouteKey]*metricsHistogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s histogram\n", name)
+
+	keys := make([]metricsRouteKey, 0, len(histograms))
+	for key := range histograms {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	for _, key := range keys {
+		h := histograms[key]
+		labels := fmt.Sprintf("route=%s,method=%s", metricsLabelValue(key.route), metricsLabelValue(key.method))
+		for i, le := range buckets {
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, metricsFloat(le), h.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", name, labels, metricsFloat(h.sum))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = m.WriteTo(w)
+}
+
+func metricsFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var metricsLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func metricsLabelValue(v string) string {
+	return `"` + metricsLabelValueReplacer.Replace(v) + `"`
+}
+
+var _ MetricsSink = (*Metrics)(nil)
+var _ http.Handler = (*Metrics)(nil)
@@ -0,0 +1,120 @@
+package httputils_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+var _ = Describe("Metrics", func() {
+	routeLabel := func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/files/") {
+			return "/files/:id"
+		}
+		return ""
+	}
+
+	It("should count requests and response sizes per route", func() {
+		metrics := NewMetrics(&MetricsOptions{
+			Namespace:   "test",
+			SizeBuckets: []float64{10, 1},
+		})
+
+		handler := MetricsMiddleware(metrics, routeLabel)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/missing" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte("hello"))
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/files/1", nil))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/files/2", nil))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/missing", nil))
+
+		var buf bytes.Buffer
+		_, err := metrics.WriteTo(&buf)
+		Expect(err).NotTo(HaveOccurred())
+		out := buf.String()
+
+		Expect(out).To(ContainSubstring("# TYPE test_http_requests_total counter\n"))
+		Expect(out).To(ContainSubstring(`test_http_requests_total{route="/files/:id",method="GET",code="200"} 2` + "\n"))
+		Expect(out).To(ContainSubstring(`test_http_requests_total{route="other",method="OTHER",code="404"} 1` + "\n"))
+		Expect(out).To(ContainSubstring(`test_http_requests_in_flight{route="/files/:id"} 0` + "\n"))
+		Expect(out).To(ContainSubstring(`test_http_response_size_bytes_bucket{route="/files/:id",method="GET",le="1"} 0` + "\n"))
+		Expect(out).To(ContainSubstring(`test_http_response_size_bytes_bucket{route="/files/:id",method="GET",le="10"} 2` + "\n"))
+		Expect(out).To(ContainSubstring(`test_http_response_size_bytes_bucket{route="/files/:id",method="GET",le="+Inf"} 2` + "\n"))
+		Expect(out).To(ContainSubstring(`test_http_response_size_bytes_sum{route="/files/:id",method="GET"} 10` + "\n"))
+		Expect(out).To(ContainSubstring(`test_http_response_size_bytes_count{route="/files/:id",method="GET"} 2` + "\n"))
+		Expect(out).To(ContainSubstring(`test_http_request_duration_seconds_count{route="other",method="OTHER"} 1` + "\n"))
+	})
+
+	It("should track in-flight requests", func() {
+		metrics := NewMetrics(nil)
+
+		var inFlight string
+
+		handler := MetricsMiddleware(metrics, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var buf bytes.Buffer
+			_, _ = metrics.WriteTo(&buf)
+			inFlight = buf.String()
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		Expect(inFlight).To(ContainSubstring(`http_requests_in_flight{route="other"} 1` + "\n"))
+	})
+
+	It("should preserve the optional interfaces of the response writer", func() {
+		metrics := NewMetrics(nil)
+
+		var isHijacker, isFlusher bool
+		handler := MetricsMiddleware(metrics, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, isHijacker = w.(http.Hijacker)
+			_, isFlusher = w.(http.Flusher)
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		Expect(isHijacker).To(BeFalse())
+		Expect(isFlusher).To(BeTrue())
+	})
+
+	It("should observe request durations", func() {
+		metrics := NewMetrics(&MetricsOptions{
+			DurationBuckets: []float64{0.001, 60},
+		})
+
+		handler := MetricsMiddleware(metrics, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(5 * time.Millisecond)
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		var buf bytes.Buffer
+		_, _ = metrics.WriteTo(&buf)
+
+		Expect(buf.String()).To(ContainSubstring(`http_request_duration_seconds_bucket{route="other",method="GET",le="0.001"} 0` + "\n"))
+		Expect(buf.String()).To(ContainSubstring(`http_request_duration_seconds_bucket{route="other",method="GET",le="60"} 1` + "\n"))
+	})
+
+	It("should serve metrics in the text exposition format", func() {
+		metrics := NewMetrics(nil)
+		metrics.RequestStarted("a\"b", "GET")
+		metrics.RequestFinished("a\"b", "GET", 200, time.Second, 0)
+
+		w := httptest.NewRecorder()
+		metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal("text/plain; version=0.0.4; charset=utf-8"))
+		Expect(w.Body.String()).To(ContainSubstring(`http_requests_total{route="a\"b",method="GET",code="200"} 1` + "\n"))
+	})
+})
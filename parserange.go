@@ -2,6 +2,7 @@ package httputils
 
 import (
 	"errors"
+	"fmt"
 	"net/textproto"
 	"strconv"
 	"strings"
@@ -11,26 +12,107 @@ import (
 
 var ErrInvalidRange = errors.New("invalid range")
 
+// ErrUnsatisfiableRange and ErrUnknownRangeUnit wrap ErrInvalidRange so that
+// errors.Is(err, ErrInvalidRange) matches every error returned by ParseRange.
+var ErrUnsatisfiableRange = fmt.Errorf("%w: unsatisfiable", ErrInvalidRange)
+var ErrUnknownRangeUnit = fmt.Errorf("%w: unknown unit", ErrInvalidRange)
+
+// RangeOutcome tells how a request with a Range header should be served
+// (RFC 7233 Section 3.1 and 4.4).
+type RangeOutcome int
+
+const (
+	// RangeIgnore means that the full representation should be served with
+	// 200. The Range header is missing, invalid or uses an unknown unit.
+	RangeIgnore RangeOutcome = iota
+	// RangeSatisfiable means that Spans should be served with 206.
+	RangeSatisfiable
+	// RangeUnsatisfiable means that the response should be 416 with a
+	// "Content-Range: bytes */size" header.
+	RangeUnsatisfiable
+)
+
+func (o RangeOutcome) String() string {
+	switch o {
+	case RangeIgnore:
+		return "ignore"
+	case RangeSatisfiable:
+		return "satisfiable"
+	case RangeUnsatisfiable:
+		return "unsatisfiable"
+	}
+	return "RangeOutcome(" + strconv.Itoa(int(o)) + ")"
+}
+
+type ParsedRange struct {
+	Outcome RangeOutcome
+	// Spans are the satisfiable ranges, clamped to size. Unsatisfiable ranges
+	// in a set with at least one satisfiable range are omitted.
+	Spans []ioutils.FileSpan
+	// HasEnd is false if any of the Spans was requested without the last
+	// byte position (e.g. "bytes=500-"), i.e. it extends to the end of the
+	// representation however long it is.
+	HasEnd bool
+	// Err is ErrInvalidRange or ErrUnknownRangeUnit for ignored headers and
+	// ErrUnsatisfiableRange for unsatisfiable ones.
+	Err error
+}
+
+// ParseRange parses a Range header value for a representation of size bytes.
+// See ParseRangeHeader for the semantics; ParseRange returns the spans only
+// for satisfiable ranges and ParsedRange.Err otherwise.
 func ParseRange(s string, size int64) (spans []ioutils.FileSpan, hasEnd bool, err error) {
 	if s == "" {
 		return nil, false, nil // header not present
 	}
 
+	parsed := ParseRangeHeader(s, size)
+	if parsed.Err != nil {
+		return nil, false, parsed.Err
+	}
+
+	return parsed.Spans, parsed.HasEnd, nil
+}
+
+// ParseRangeHeader parses a Range header value for a representation of size
+// bytes. Syntactically invalid headers and unknown units are ignored. A range
+// is satisfiable if its first byte position is less than size or if it is a
+// suffix range with a non-zero length. A zero length representation has no
+// bytes to serve in a 206 so ranges of it are either ignored (non-zero
+// suffix) or unsatisfiable.
+func ParseRangeHeader(s string, size int64) *ParsedRange {
+	if s == "" {
+		return &ParsedRange{Outcome: RangeIgnore, HasEnd: true}
+	}
+
+	ignore := func(err error) *ParsedRange {
+		return &ParsedRange{Outcome: RangeIgnore, Err: err}
+	}
+
 	const b = "bytes="
 	if !strings.HasPrefix(s, b) {
-		return nil, false, ErrInvalidRange
+		if i := strings.IndexByte(s, '='); i > 0 && isRangeUnit(s[:i]) {
+			return ignore(ErrUnknownRangeUnit)
+		}
+		return ignore(ErrInvalidRange)
 	}
 
-	hasEnd = true
+	parsed := &ParsedRange{
+		Outcome: RangeIgnore,
+		HasEnd:  true,
+	}
+	specs := 0
+	satisfiableSuffix := false
 
 	for _, ra := range strings.Split(s[len(b):], ",") {
 		ra = textproto.TrimString(ra)
 		if ra == "" {
 			continue
 		}
+		specs++
 		i := strings.Index(ra, "-")
 		if i < 0 {
-			return nil, false, ErrInvalidRange
+			return ignore(ErrInvalidRange)
 		}
 		start, end := textproto.TrimString(ra[:i]), textproto.TrimString(ra[i+1:])
 		var s ioutils.FileSpan
@@ -41,11 +123,19 @@ func ParseRange(s string, size int64) (spans []ioutils.FileSpan, hasEnd bool, er
 			// which has to be a non-negative integer as per
 			// RFC 7233 Section 2.1 "Byte-Ranges".
 			if end == "" || end[0] == '-' {
-				return nil, false, ErrInvalidRange
+				return ignore(ErrInvalidRange)
 			}
 			i, err := strconv.ParseInt(end, 10, 64)
 			if i < 0 || err != nil {
-				return nil, false, ErrInvalidRange
+				return ignore(ErrInvalidRange)
+			}
+			if i == 0 {
+				// a zero length suffix is valid but never satisfiable
+				continue
+			}
+			if size == 0 {
+				satisfiableSuffix = true
+				continue
 			}
 			if i > size {
 				i = size
@@ -54,27 +144,59 @@ func ParseRange(s string, size int64) (spans []ioutils.FileSpan, hasEnd bool, er
 			s.End = size - 1
 		} else {
 			i, err := strconv.ParseInt(start, 10, 64)
-			if err != nil || i >= size || i < 0 {
-				return nil, false, ErrInvalidRange
+			if err != nil || i < 0 {
+				return ignore(ErrInvalidRange)
 			}
 			s.Start = i
-			if end == "" {
-				// If no end is specified, range extends to end of the file.
-				s.End = size - 1
-				hasEnd = false
-			} else {
+			hasEnd := end != ""
+			if hasEnd {
 				i, err := strconv.ParseInt(end, 10, 64)
 				if err != nil || s.Start > i {
-					return nil, false, ErrInvalidRange
-				}
-				if i >= size {
-					i = size - 1
+					return ignore(ErrInvalidRange)
 				}
 				s.End = i
 			}
+			if s.Start >= size {
+				continue
+			}
+			if !hasEnd || s.End >= size {
+				// If no end is specified, range extends to end of the file.
+				s.End = size - 1
+			}
+			if !hasEnd {
+				parsed.HasEnd = false
+			}
 		}
-		spans = append(spans, s)
+		parsed.Spans = append(parsed.Spans, s)
+	}
+
+	switch {
+	case len(parsed.Spans) > 0:
+		parsed.Outcome = RangeSatisfiable
+	case specs == 0:
+		// empty range set
+	case satisfiableSuffix:
+		// the suffix of a zero length representation is the whole (empty)
+		// representation
+	default:
+		parsed.Outcome = RangeUnsatisfiable
+		parsed.Err = ErrUnsatisfiableRange
 	}
 
-	return spans, hasEnd, nil
+	return parsed
+}
+
+// isRangeUnit reports whether s is a valid range unit token (RFC 7230
+// Section 3.2.6).
+func isRangeUnit(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
 }
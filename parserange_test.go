@@ -1,6 +1,8 @@
 package httputils_test
 
 import (
+	"errors"
+
 	"github.com/koofr/go-ioutils"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -16,7 +18,8 @@ var _ = Describe("ParseRange", func() {
 
 	It("should only parse bytes", func() {
 		_, _, err := ParseRange("items=0-5", 1000)
-		Expect(err).To(Equal(ErrInvalidRange))
+		Expect(err).To(Equal(ErrUnknownRangeUnit))
+		Expect(errors.Is(err, ErrInvalidRange)).To(BeTrue())
 	})
 
 	It("should not parse range if start is greater than end", func() {
@@ -26,7 +29,8 @@ var _ = Describe("ParseRange", func() {
 		_, _, err = ParseRange("bytes=199-", 200)
 		Expect(err).NotTo(HaveOccurred())
 		_, _, err = ParseRange("bytes=200-", 200)
-		Expect(err).To(Equal(ErrInvalidRange))
+		Expect(err).To(Equal(ErrUnsatisfiableRange))
+		Expect(errors.Is(err, ErrInvalidRange)).To(BeTrue())
 	})
 
 	It("should parse range if start and end exist", func() {
@@ -112,7 +116,58 @@ var _ = Describe("ParseRange", func() {
 		Entry("end only", "bytes=-6", true, 194, 199, true),
 		Entry("start only", "bytes=6-", true, 6, 199, false),
 		Entry("invalid end", "bytes=-6-", false, 0, 0, false),
-		Entry("zero end", "bytes=-0", true, 200, 199, true),
 		Entry("empty range", "bytes=", true, -1, -1, true),
 	)
+
+	It("should not satisfy a zero length suffix", func() {
+		_, _, err := ParseRange("bytes=-0", 200)
+		Expect(err).To(Equal(ErrUnsatisfiableRange))
+	})
+
+	It("should omit unsatisfiable ranges in a satisfiable set", func() {
+		spans, hasEnd, err := ParseRange("bytes=500-,40-80,-0", 200)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spans).To(Equal([]ioutils.FileSpan{{Start: 40, End: 80}}))
+		Expect(hasEnd).To(BeTrue())
+	})
+})
+
+var _ = Describe("ParseRangeHeader", func() {
+	DescribeTable(
+		"Outcomes",
+		func(rng string, size int, expectedOutcome RangeOutcome, expectedSpans []ioutils.FileSpan, expectedHasEnd bool, expectedErr error) {
+			parsed := ParseRangeHeader(rng, int64(size))
+			Expect(parsed.Outcome).To(Equal(expectedOutcome))
+			Expect(parsed.Spans).To(Equal(expectedSpans))
+			Expect(parsed.HasEnd).To(Equal(expectedHasEnd))
+			if expectedErr == nil {
+				Expect(parsed.Err).To(BeNil())
+			} else {
+				Expect(parsed.Err).To(Equal(expectedErr))
+			}
+		},
+		Entry("missing header", "", 200, RangeIgnore, nil, true, nil),
+		Entry("malformed", "malformed", 200, RangeIgnore, nil, false, ErrInvalidRange),
+		Entry("unknown unit", "items=0-5", 200, RangeIgnore, nil, false, ErrUnknownRangeUnit),
+		Entry("invalid unit", "by tes=0-5", 200, RangeIgnore, nil, false, ErrInvalidRange),
+		Entry("invalid spec", "bytes=5", 200, RangeIgnore, nil, false, ErrInvalidRange),
+		Entry("invalid spec in set", "bytes=0-5,10-1", 200, RangeIgnore, nil, false, ErrInvalidRange),
+		Entry("empty range set", "bytes=", 200, RangeIgnore, nil, true, nil),
+		Entry("satisfiable", "bytes=0-5", 200, RangeSatisfiable, []ioutils.FileSpan{{Start: 0, End: 5}}, true, nil),
+		Entry("satisfiable without end", "bytes=0-5,100-", 200, RangeSatisfiable, []ioutils.FileSpan{{Start: 0, End: 5}, {Start: 100, End: 199}}, false, nil),
+		Entry("end after size", "bytes=100-1000", 200, RangeSatisfiable, []ioutils.FileSpan{{Start: 100, End: 199}}, true, nil),
+		Entry("suffix longer than size", "bytes=-1000", 200, RangeSatisfiable, []ioutils.FileSpan{{Start: 0, End: 199}}, true, nil),
+		Entry("unsatisfiable without end", "bytes=200-", 200, RangeUnsatisfiable, nil, true, ErrUnsatisfiableRange),
+		Entry("unsatisfiable ranges are omitted", "bytes=200-,0-0", 200, RangeSatisfiable, []ioutils.FileSpan{{Start: 0, End: 0}}, true, nil),
+		Entry("unsatisfiable set", "bytes=300-400,-0", 200, RangeUnsatisfiable, nil, true, ErrUnsatisfiableRange),
+		Entry("empty representation", "bytes=0-", 0, RangeUnsatisfiable, nil, true, ErrUnsatisfiableRange),
+		Entry("empty representation suffix", "bytes=-5", 0, RangeIgnore, nil, true, nil),
+		Entry("empty representation zero suffix", "bytes=-0", 0, RangeUnsatisfiable, nil, true, ErrUnsatisfiableRange),
+	)
+
+	It("should format outcomes", func() {
+		Expect(RangeIgnore.String()).To(Equal("ignore"))
+		Expect(RangeSatisfiable.String()).To(Equal("satisfiable"))
+		Expect(RangeUnsatisfiable.String()).To(Equal("unsatisfiable"))
+	})
 })
@@ -0,0 +1,98 @@
+package httputils
+
+import (
+	"errors"
+	"net/http"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details response.
+type Problem struct {
+	Type     string           `json:"type,omitempty"`
+	Title    string           `json:"title,omitempty"`
+	Status   int              `json:"status,omitempty"`
+	Detail   string           `json:"detail,omitempty"`
+	Instance string           `json:"instance,omitempty"`
+	Errors   ValidationErrors `json:"errors,omitempty"`
+}
+
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func ResponseProblem(w http.ResponseWriter, r *http.Request, problem *Problem) error {
+	return ResponseJSONWithOptions(w, r, problem.Status, problem, &ResponseJSONOptions{
+		ContentType: ProblemContentType,
+	})
+}
+
+// RequestErrorStatusCode maps errors returned by the request helpers to HTTP
+// status codes.
+func RequestErrorStatusCode(err error) int {
+	var errInvalidContentType *ErrInvalidContentType
+	var errValidation *ErrValidation
+	var errJSONPatchTestFailed *ErrJSONPatchTestFailed
+	var errJSONPatchInvalidPath *ErrJSONPatchInvalidPath
+
+	switch {
+	case errors.As(err, &errInvalidContentType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrRequestBodyTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &errValidation):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &errJSONPatchTestFailed):
+		return http.StatusConflict
+	case errors.As(err, &errJSONPatchInvalidPath):
+		return http.StatusUnprocessableEntity
+	}
+
+	return http.StatusBadRequest
+}
+
+// ErrorStatusCode maps handler errors to HTTP status codes. Errors
+// implementing StatusCoder choose their own status code, errors returned by
+// the request helpers are mapped with RequestErrorStatusCode and validation
+// errors result in 422. All other errors result in 500.
+func ErrorStatusCode(err error) int {
+	var statusCoder StatusCoder
+	var errRequestJSON *ErrRequestJSON
+	var errRequestQuery *ErrRequestQuery
+	var errRequestForm *ErrRequestForm
+
+	switch {
+	case errors.As(err, &statusCoder):
+		return statusCoder.StatusCode()
+	case errors.As(err, &errRequestJSON), errors.As(err, &errRequestQuery), errors.As(err, &errRequestForm):
+		return RequestErrorStatusCode(err)
+	case ValidationErrorsFrom(err) != nil:
+		return http.StatusUnprocessableEntity
+	}
+
+	return http.StatusInternalServerError
+}
+
+// ResponseRequestError writes a problem response for an error returned by the
+// request helpers. Validation errors are included in the errors field.
+func ResponseRequestError(w http.ResponseWriter, r *http.Request, err error) error {
+	return ResponseError(w, r, RequestErrorStatusCode(err), err)
+}
+
+// ResponseError writes a problem response for err. The error message is only
+// exposed as the detail for 4xx status codes.
+func ResponseError(w http.ResponseWriter, r *http.Request, statusCode int, err error) error {
+	detail := ""
+	if statusCode >= 400 && statusCode <= 499 {
+		detail = err.Error()
+	}
+
+	problem := NewProblem(statusCode, detail)
+	problem.Errors = ValidationErrorsFrom(err)
+
+	return ResponseProblem(w, r, problem)
+}
@@ -1,11 +1,8 @@
 package httputils
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
-	"mime"
 	"net/http"
 
 	"github.com/koofr/go-ioutils"
@@ -65,19 +62,53 @@ func (e *ErrInvalidJSON) Unwrap() error {
 	return e.Err
 }
 
+// DefaultMaxRequestJSONSize is used when no RequestJSONOptions or no MaxSize
+// are given.
+const DefaultMaxRequestJSONSize = 1024 * 1024
+
+type RequestJSONOptions struct {
+	// MaxSize is the maximum request body size in bytes after decoding
+	// Content-Encoding. Defaults to DefaultMaxRequestJSONSize.
+	MaxSize int
+	// Codec is used to decode the body. Defaults to DefaultJSONCodec.
+	Codec JSONCodec
+	// ContentTypePolicy defaults to accepting only application/json.
+	ContentTypePolicy *ContentTypePolicy
+}
+
 func RequestJSONError(r *http.Request, v interface{}, maxRequestJSONSize int) (jsonBytes []byte, err error) {
+	return RequestJSONErrorWithOptions(r, v, &RequestJSONOptions{
+		MaxSize: maxRequestJSONSize,
+	})
+}
+
+func RequestJSONErrorWithOptions(r *http.Request, v interface{}, opts *RequestJSONOptions) (jsonBytes []byte, err error) {
 	defer r.Body.Close()
 
-	contentType := r.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(contentType)
+	if opts == nil {
+		opts = &RequestJSONOptions{MaxSize: DefaultMaxRequestJSONSize}
+	}
+
+	contentTypePolicy := opts.ContentTypePolicy
+	if contentTypePolicy == nil {
+		contentTypePolicy = defaultContentTypePolicy
+	}
+	if err := contentTypePolicy.check(r.Header.Get("Content-Type")); err != nil {
+		return nil, NewErrRequestJSON(err)
+	}
+
+	body, err := decodeContentEncoding(r.Header.Get("Content-Encoding"), r.Body)
 	if err != nil {
-		return nil, NewErrRequestJSON(NewErrInvalidContentType(err))
+		return nil, NewErrRequestJSON(err)
 	}
-	if mediaType != "application/json" {
-		return nil, NewErrRequestJSON(NewErrInvalidContentType(fmt.Errorf("expected Content-Type to be application/json but got: %s", mediaType)))
+
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxRequestJSONSize
 	}
 
-	reader := ioutils.NewSizeLimitedReader(r.Body, int64(maxRequestJSONSize))
+	// the limit applies to the decoded size to prevent decompression bombs
+	reader := ioutils.NewSizeLimitedReader(body, int64(maxSize))
 
 	jsonBytes, err = ioutil.ReadAll(reader)
 	if err != nil {
@@ -87,10 +118,14 @@ func RequestJSONError(r *http.Request, v interface{}, maxRequestJSONSize int) (j
 		return nil, NewErrRequestJSON(err)
 	}
 
-	err = json.Unmarshal(jsonBytes, v)
+	err = jsonCodecOrDefault(opts.Codec).Unmarshal(jsonBytes, v)
 	if err != nil {
 		return nil, NewErrRequestJSON(NewErrInvalidJSON(err, jsonBytes))
 	}
 
+	if err := validate(v); err != nil {
+		return nil, NewErrRequestJSON(err)
+	}
+
 	return jsonBytes, nil
 }
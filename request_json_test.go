@@ -2,8 +2,12 @@ package httputils_test
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"errors"
 	"io"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 
@@ -93,5 +97,156 @@ var _ = Describe("RequestJSON", func() {
 				"foo": "bar",
 			}))
 		})
+
+		It("should use the default size limit if MaxSize is not set", func() {
+			v := map[string]interface{}{}
+			r := httptest.NewRequest("GET", "/", strings.NewReader(`{"foo": "bar"}`))
+			r.Header.Set("Content-Type", "application/json")
+			_, err := RequestJSONErrorWithOptions(r, &v, &RequestJSONOptions{Codec: DefaultJSONCodec})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v).To(HaveKeyWithValue("foo", "bar"))
+
+			r = httptest.NewRequest("GET", "/", strings.NewReader(`"`+strings.Repeat("a", DefaultMaxRequestJSONSize)+`"`))
+			r.Header.Set("Content-Type", "application/json")
+			_, err = RequestJSONErrorWithOptions(r, &v, &RequestJSONOptions{})
+			Expect(errors.Is(err, ErrRequestBodyTooLarge)).To(BeTrue())
+		})
+	})
+
+	Describe("Content-Encoding", func() {
+		compress := func(encoding string, data []byte) []byte {
+			var buf bytes.Buffer
+			var w io.WriteCloser
+			switch encoding {
+			case "gzip":
+				w = gzip.NewWriter(&buf)
+			case "zlib":
+				w = zlib.NewWriter(&buf)
+			case "flate":
+				w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+			}
+			_, _ = w.Write(data)
+			_ = w.Close()
+			return buf.Bytes()
+		}
+
+		newEncodedRequest := func(contentEncoding string, body []byte) *http.Request {
+			r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Content-Encoding", contentEncoding)
+			return r
+		}
+
+		DescribeTable("should decode compressed bodies",
+			func(contentEncoding string, body []byte) {
+				v := map[string]interface{}{}
+				jsonBytes, err := RequestJSONError(newEncodedRequest(contentEncoding, body), &v, 1024)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(jsonBytes)).To(Equal(`{"foo": "bar"}`))
+				Expect(v).To(Equal(map[string]interface{}{"foo": "bar"}))
+			},
+			Entry("gzip", "gzip", compress("gzip", []byte(`{"foo": "bar"}`))),
+			Entry("x-gzip", "x-gzip", compress("gzip", []byte(`{"foo": "bar"}`))),
+			Entry("zlib deflate", "deflate", compress("zlib", []byte(`{"foo": "bar"}`))),
+			Entry("raw deflate", "deflate", compress("flate", []byte(`{"foo": "bar"}`))),
+			Entry("identity", "identity", []byte(`{"foo": "bar"}`)),
+			Entry("multiple encodings", "deflate, gzip", compress("gzip", compress("zlib", []byte(`{"foo": "bar"}`)))),
+		)
+
+		It("should apply the size limit to the decompressed body", func() {
+			body := compress("gzip", bytes.Repeat([]byte(" "), 1024*1024))
+			Expect(len(body)).To(BeNumerically("<", 10*1024))
+
+			_, err := RequestJSONError(newEncodedRequest("gzip", body), nil, 10*1024)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("request json error: request body too large"))
+		})
+
+		It("should reject unsupported encodings", func() {
+			_, err := RequestJSONError(newEncodedRequest("br", []byte("{}")), nil, 1024)
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrUnsupportedContentEncoding)).To(BeTrue())
+			var errInvalidContentType *ErrInvalidContentType
+			Expect(errors.As(err, &errInvalidContentType)).To(BeTrue())
+			Expect(err.Error()).To(Equal("request json error: invalid content type: unsupported content encoding: br"))
+			Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusUnsupportedMediaType))
+		})
+
+		It("should handle corrupt gzip bodies", func() {
+			_, err := RequestJSONError(newEncodedRequest("gzip", []byte("not a gzip stream")), nil, 1024)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("request json error: gzip: invalid header"))
+			Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("ContentTypePolicy", func() {
+		request := func(contentType string, opts *RequestJSONOptions) error {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+			if contentType != "" {
+				r.Header.Set("Content-Type", contentType)
+			}
+			v := map[string]interface{}{}
+			_, err := RequestJSONErrorWithOptions(r, &v, opts)
+			return err
+		}
+
+		It("should accept a UTF-8 charset by default", func() {
+			Expect(request("application/json; charset=utf-8", &RequestJSONOptions{MaxSize: 1024})).To(Succeed())
+			Expect(request("application/json; charset=UTF8", &RequestJSONOptions{MaxSize: 1024})).To(Succeed())
+		})
+
+		It("should reject non UTF-8 charsets", func() {
+			err := request("application/json; charset=iso-8859-1", &RequestJSONOptions{MaxSize: 1024})
+			Expect(err).To(HaveOccurred())
+			var errInvalidContentType *ErrInvalidContentType
+			Expect(errors.As(err, &errInvalidContentType)).To(BeTrue())
+			Expect(err.Error()).To(Equal("request json error: invalid content type: unsupported charset: iso-8859-1"))
+		})
+
+		It("should reject +json media types by default", func() {
+			err := request("application/merge-patch+json", &RequestJSONOptions{MaxSize: 1024})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("request json error: invalid content type: expected Content-Type to be application/json but got: application/merge-patch+json"))
+		})
+
+		It("should accept +json media types if allowed", func() {
+			opts := &RequestJSONOptions{
+				MaxSize:           1024,
+				ContentTypePolicy: &ContentTypePolicy{AllowJSONSuffix: true},
+			}
+
+			Expect(request("application/merge-patch+json", opts)).To(Succeed())
+			Expect(request("application/vnd.koofr.v2+json", opts)).To(Succeed())
+			Expect(request("application/problem+json", opts)).To(Succeed())
+			Expect(request("application/json", opts)).To(Succeed())
+
+			err := request("text/plain", opts)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("request json error: invalid content type: expected Content-Type to be application/json or a +json media type but got: text/plain"))
+		})
+
+		It("should accept an explicit allow-list", func() {
+			opts := &RequestJSONOptions{
+				MaxSize:           1024,
+				ContentTypePolicy: &ContentTypePolicy{Allowed: []string{"application/vnd.koofr.v2+json", "text/json"}},
+			}
+
+			Expect(request("text/json", opts)).To(Succeed())
+
+			err := request("application/json", opts)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("request json error: invalid content type: expected Content-Type to be one of application/vnd.koofr.v2+json, text/json but got: application/json"))
+		})
+
+		It("should accept a missing Content-Type if allowed", func() {
+			err := request("", &RequestJSONOptions{MaxSize: 1024})
+			Expect(err).To(HaveOccurred())
+
+			Expect(request("", &RequestJSONOptions{
+				MaxSize:           1024,
+				ContentTypePolicy: &ContentTypePolicy{AllowMissing: true},
+			})).To(Succeed())
+		})
 	})
 })
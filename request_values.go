@@ -0,0 +1,320 @@
+package httputils
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koofr/go-ioutils"
+)
+
+// FormContentType is the Content-Type accepted by RequestForm.
+const FormContentType = "application/x-www-form-urlencoded"
+
+var formContentTypePolicy = &ContentTypePolicy{
+	Allowed: []string{FormContentType},
+}
+
+type ErrRequestQuery struct {
+	Err error
+}
+
+func NewErrRequestQuery(err error) *ErrRequestQuery {
+	return &ErrRequestQuery{Err: err}
+}
+
+func (e *ErrRequestQuery) Error() string {
+	return "request query error: " + e.Err.Error()
+}
+
+func (e *ErrRequestQuery) Unwrap() error {
+	return e.Err
+}
+
+type ErrRequestForm struct {
+	Err error
+}
+
+func NewErrRequestForm(err error) *ErrRequestForm {
+	return &ErrRequestForm{Err: err}
+}
+
+func (e *ErrRequestForm) Error() string {
+	return "request form error: " + e.Err.Error()
+}
+
+func (e *ErrRequestForm) Unwrap() error {
+	return e.Err
+}
+
+// ErrInvalidField is returned when a query or form value cannot be converted
+// to the type of its struct field.
+type ErrInvalidField struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func NewErrInvalidField(field string, value string, err error) *ErrInvalidField {
+	return &ErrInvalidField{
+		Field: field,
+		Value: value,
+		Err:   err,
+	}
+}
+
+func (e *ErrInvalidField) Error() string {
+	return fmt.Sprintf("%s: invalid value %q: %s", e.Field, e.Value, e.Err.Error())
+}
+
+func (e *ErrInvalidField) Unwrap() error {
+	return e.Err
+}
+
+// ErrInvalidFields contains an error for every field that could not be
+// decoded.
+type ErrInvalidFields []*ErrInvalidField
+
+func (e ErrInvalidFields) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// RequestQuery decodes the URL query into the struct pointed to by v and
+// validates it. Field names are taken from the "query" tag, defaulting to the
+// Go field name. Fields tagged with "-" are skipped.
+//
+// Supported field types are strings, bools, ints, uints, floats, time.Time
+// (RFC 3339), time.Duration, encoding.TextUnmarshaler and slices of and
+// pointers to these. Empty values are ignored, except for bools, where an
+// empty value (e.g. "?pretty") means true.
+func RequestQuery(r *http.Request, v interface{}) error {
+	if err := decodeValues(r.URL.Query(), v, "query"); err != nil {
+		return NewErrRequestQuery(err)
+	}
+
+	if err := validate(v); err != nil {
+		return NewErrRequestQuery(err)
+	}
+
+	return nil
+}
+
+// RequestForm decodes an application/x-www-form-urlencoded request body into
+// the struct pointed to by v and validates it. Values are mapped using the
+// "form" tag, see RequestQuery. URL query values are not included.
+func RequestForm(r *http.Request, v interface{}, maxRequestFormSize int) error {
+	defer r.Body.Close()
+
+	if err := formContentTypePolicy.check(r.Header.Get("Content-Type")); err != nil {
+		return NewErrRequestForm(err)
+	}
+
+	body, err := decodeContentEncoding(r.Header.Get("Content-Encoding"), r.Body)
+	if err != nil {
+		return NewErrRequestForm(err)
+	}
+
+	reader := ioutils.NewSizeLimitedReader(body, int64(maxRequestFormSize))
+
+	formBytes, err := ioutil.ReadAll(reader)
+	if err != nil {
+		if errors.Is(err, ioutils.ErrMaxSizeExceeded) {
+			return NewErrRequestForm(ErrRequestBodyTooLarge)
+		}
+		return NewErrRequestForm(err)
+	}
+
+	values, err := url.ParseQuery(string(formBytes))
+	if err != nil {
+		return NewErrRequestForm(err)
+	}
+
+	if err := decodeValues(values, v, "form"); err != nil {
+		return NewErrRequestForm(err)
+	}
+
+	if err := validate(v); err != nil {
+		return NewErrRequestForm(err)
+	}
+
+	return nil
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+)
+
+func decodeValues(values url.Values, v interface{}, tagName string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected a non-nil struct pointer but got: %T", v)
+	}
+
+	var errs ErrInvalidFields
+	decodeStructValues(values, rv.Elem(), tagName, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func decodeStructValues(values url.Values, rv reflect.Value, tagName string, errs *ErrInvalidFields) {
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+
+		tag := field.Tag.Get(tagName)
+		if tag == "-" {
+			continue
+		}
+
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			decodeStructValues(values, rv.Field(i), tagName, errs)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldValues, ok := values[name]
+		if !ok || len(fieldValues) == 0 {
+			continue
+		}
+
+		fv := rv.Field(i)
+
+		if fv.Kind() == reflect.Slice && !fv.Type().Implements(textUnmarshalerType) && !reflect.PtrTo(fv.Type()).Implements(textUnmarshalerType) {
+			slice := reflect.MakeSlice(fv.Type(), 0, len(fieldValues))
+			failed := false
+			for _, value := range fieldValues {
+				elem := reflect.New(fv.Type().Elem()).Elem()
+				if err := decodeValue(elem, value); err != nil {
+					*errs = append(*errs, NewErrInvalidField(name, value, err))
+					failed = true
+					continue
+				}
+				slice = reflect.Append(slice, elem)
+			}
+			if !failed {
+				fv.Set(slice)
+			}
+			continue
+		}
+
+		// the last value wins, like for repeated JSON keys
+		value := fieldValues[len(fieldValues)-1]
+		if err := decodeValue(fv, value); err != nil {
+			*errs = append(*errs, NewErrInvalidField(name, value, err))
+		}
+	}
+}
+
+func decodeValue(fv reflect.Value, value string) error {
+	if fv.Kind() == reflect.Ptr {
+		// empty values only set strings and bools, so they must not
+		// allocate other pointers
+		if k := fv.Type().Elem().Kind(); value == "" && k != reflect.String && k != reflect.Bool {
+			return nil
+		}
+		elem := reflect.New(fv.Type().Elem())
+		if err := decodeValue(elem.Elem(), value); err != nil {
+			return err
+		}
+		fv.Set(elem)
+		return nil
+	}
+
+	if reflect.PtrTo(fv.Type()).Implements(textUnmarshalerType) && fv.Type() != timeType {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	if fv.Kind() == reflect.String {
+		fv.SetString(value)
+		return nil
+	}
+
+	if fv.Kind() == reflect.Bool && value == "" {
+		fv.SetBool(true)
+		return nil
+	}
+
+	if value == "" {
+		return nil
+	}
+
+	switch fv.Type() {
+	case timeType:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return errors.New("expected an RFC 3339 time")
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New("expected a duration")
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("expected a boolean")
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return numError("an integer", err)
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return numError("an unsigned integer", err)
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return numError("a number", err)
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type: %s", fv.Type())
+	}
+
+	return nil
+}
+
+func numError(expected string, err error) error {
+	if errors.Is(err, strconv.ErrRange) {
+		return errors.New("value out of range")
+	}
+	return errors.New("expected " + expected)
+}
@@ -0,0 +1,171 @@
+package httputils_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+type listQuery struct {
+	Limit   int           `query:"limit"`
+	Sort    string        `query:"sort"`
+	Desc    bool          `query:"desc"`
+	Tags    []string      `query:"tag"`
+	IDs     []int64       `query:"id"`
+	Offset  *uint         `query:"offset"`
+	Since   time.Time     `query:"since"`
+	Timeout time.Duration `query:"timeout"`
+	Ratio   float64       `query:"ratio"`
+	IP      net.IP        `query:"ip"`
+	Ignored string        `query:"-"`
+	Name    string
+}
+
+type validatedQuery struct {
+	Limit int `query:"limit"`
+}
+
+func (q *validatedQuery) Validate() error {
+	if q.Limit > 100 {
+		return NewValidationError("limit", "max", "limit must be at most 100")
+	}
+	return nil
+}
+
+type loginForm struct {
+	Username string `form:"username"`
+	Remember bool   `form:"remember"`
+}
+
+func newFormRequest(body string) *http.Request {
+	r := httptest.NewRequest("POST", "/?username=query", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+var _ = Describe("RequestQuery", func() {
+	It("should decode query values", func() {
+		r := httptest.NewRequest("GET", "/?limit=10&sort=name&desc&tag=a&tag=b&id=1&id=2&offset=5&since=2020-01-02T03:04:05Z&timeout=1m30s&ratio=0.5&ip=127.0.0.1&Ignored=x&Name=n", nil)
+
+		q := &listQuery{}
+		Expect(RequestQuery(r, q)).To(Succeed())
+
+		offset := uint(5)
+		Expect(q).To(Equal(&listQuery{
+			Limit:   10,
+			Sort:    "name",
+			Desc:    true,
+			Tags:    []string{"a", "b"},
+			IDs:     []int64{1, 2},
+			Offset:  &offset,
+			Since:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			Timeout: 90 * time.Second,
+			Ratio:   0.5,
+			IP:      net.ParseIP("127.0.0.1"),
+			Name:    "n",
+		}))
+	})
+
+	It("should leave missing and empty values unchanged", func() {
+		r := httptest.NewRequest("GET", "/?limit=&offset=", nil)
+
+		q := &listQuery{Limit: 20}
+		Expect(RequestQuery(r, q)).To(Succeed())
+		Expect(q.Limit).To(Equal(20))
+		Expect(q.Offset).To(BeNil())
+	})
+
+	It("should not allocate pointers for empty values", func() {
+		r := httptest.NewRequest("GET", "/?limit=&name=&all=", nil)
+
+		q := &struct {
+			Limit *int    `query:"limit"`
+			Name  *string `query:"name"`
+			All   *bool   `query:"all"`
+		}{}
+		Expect(RequestQuery(r, q)).To(Succeed())
+		Expect(q.Limit).To(BeNil())
+		Expect(q.Name).To(Equal(new(string)))
+		Expect(*q.All).To(BeTrue())
+	})
+
+	It("should return field errors", func() {
+		r := httptest.NewRequest("GET", "/?limit=abc&desc=maybe&id=1&id=x&since=yesterday&limit8=1", nil)
+
+		err := RequestQuery(r, &listQuery{})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal(`request query error: limit: invalid value "abc": expected an integer; desc: invalid value "maybe": expected a boolean; id: invalid value "x": expected an integer; since: invalid value "yesterday": expected an RFC 3339 time`))
+
+		var errInvalidFields ErrInvalidFields
+		Expect(errors.As(err, &errInvalidFields)).To(BeTrue())
+		Expect(errInvalidFields).To(HaveLen(4))
+		Expect(errInvalidFields[0].Field).To(Equal("limit"))
+		Expect(errInvalidFields[0].Value).To(Equal("abc"))
+
+		Expect(ErrorStatusCode(err)).To(Equal(http.StatusBadRequest))
+		Expect(ValidationErrorsFrom(err)[0]).To(Equal(NewValidationError("limit", "invalid", "expected an integer")))
+	})
+
+	It("should report out of range values", func() {
+		r := httptest.NewRequest("GET", "/?limit=99999999999999999999", nil)
+
+		err := RequestQuery(r, &listQuery{})
+		Expect(err).To(MatchError(`request query error: limit: invalid value "99999999999999999999": value out of range`))
+	})
+
+	It("should validate the decoded value", func() {
+		r := httptest.NewRequest("GET", "/?limit=1000", nil)
+
+		err := RequestQuery(r, &validatedQuery{})
+		Expect(err).To(MatchError("request query error: validation failed: limit: limit must be at most 100"))
+		Expect(ErrorStatusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("should require a struct pointer", func() {
+		r := httptest.NewRequest("GET", "/", nil)
+
+		Expect(RequestQuery(r, listQuery{})).To(MatchError("request query error: expected a non-nil struct pointer but got: httputils_test.listQuery"))
+	})
+})
+
+var _ = Describe("RequestForm", func() {
+	It("should decode form values", func() {
+		f := &loginForm{}
+		Expect(RequestForm(newFormRequest("username=john&remember=true"), f, 1024)).To(Succeed())
+		Expect(f).To(Equal(&loginForm{Username: "john", Remember: true}))
+	})
+
+	It("should check the content type", func() {
+		r := newFormRequest("username=john")
+		r.Header.Set("Content-Type", "application/json")
+
+		err := RequestForm(r, &loginForm{}, 1024)
+		Expect(err).To(MatchError("request form error: invalid content type: expected Content-Type to be application/x-www-form-urlencoded but got: application/json"))
+		Expect(ErrorStatusCode(err)).To(Equal(http.StatusUnsupportedMediaType))
+	})
+
+	It("should limit the body size", func() {
+		err := RequestForm(newFormRequest("username=john"), &loginForm{}, 4)
+		Expect(errors.Is(err, ErrRequestBodyTooLarge)).To(BeTrue())
+		Expect(ErrorStatusCode(err)).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("should return field errors", func() {
+		err := RequestForm(newFormRequest("remember=sometimes"), &loginForm{}, 1024)
+		Expect(err).To(MatchError(`request form error: remember: invalid value "sometimes": expected a boolean`))
+	})
+
+	It("should return an error for an invalid body", func() {
+		err := RequestForm(newFormRequest("username=%zz"), &loginForm{}, 1024)
+		Expect(err).To(HaveOccurred())
+		Expect(ErrorStatusCode(err)).To(Equal(http.StatusBadRequest))
+	})
+})
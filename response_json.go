@@ -1,27 +1,194 @@
 package httputils
 
 import (
+	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
+// CachePolicy controls the caching headers set by ResponseJSONWithOptions.
+type CachePolicy struct {
+	// ETag enables a strong ETag computed from the response body and
+	// 304 Not Modified responses for matching If-None-Match requests.
+	ETag         bool
+	CacheControl string
+	Vary         []string
+}
+
+// JSONHijackingPrefix can be used as ResponseJSONOptions.Prefix to defend
+// against JSON hijacking. Clients must strip it before parsing.
+const JSONHijackingPrefix = ")]}',\n"
+
+const defaultJSONIndent = "  "
+
+type ResponseJSONOptions struct {
+	Cache *CachePolicy
+	// Pretty indents the output.
+	Pretty bool
+	// PrettyQuery lets clients enable pretty output with the "pretty" query
+	// parameter ("?pretty", "?pretty=true", "?pretty=1").
+	PrettyQuery bool
+	// Indent is used for pretty output. Defaults to two spaces.
+	Indent string
+	// DisableHTMLEscape disables escaping of <, > and & when encoding values.
+	// Bytes passed to ResponseJSONBytes are never modified.
+	DisableHTMLEscape bool
+	// Prefix is written before the JSON body, e.g. JSONHijackingPrefix.
+	Prefix string
+	// DisableNoSniff disables the X-Content-Type-Options: nosniff header.
+	DisableNoSniff bool
+	// Codec is used to encode values. Defaults to DefaultJSONCodec.
+	Codec JSONCodec
+	// ContentType defaults to application/json; charset=utf-8.
+	ContentType string
+}
+
+func (o *ResponseJSONOptions) pretty(r *http.Request) bool {
+	if o.Pretty {
+		return true
+	}
+	if !o.PrettyQuery || r == nil || r.URL == nil {
+		return false
+	}
+	values, ok := r.URL.Query()["pretty"]
+	if !ok {
+		return false
+	}
+	if values[0] == "" {
+		return true
+	}
+	pretty, _ := strconv.ParseBool(values[0])
+	return pretty
+}
+
+func (o *ResponseJSONOptions) indent() string {
+	if o.Indent != "" {
+		return o.Indent
+	}
+	return defaultJSONIndent
+}
+
 func ResponseJSON(w http.ResponseWriter, r *http.Request, code int, v interface{}) error {
-	jsonBytes, err := json.Marshal(v)
-	if err != nil {
+	return ResponseJSONWithOptions(w, r, code, v, nil)
+}
+
+func ResponseJSONWithOptions(w http.ResponseWriter, r *http.Request, code int, v interface{}, opts *ResponseJSONOptions) error {
+	if opts == nil {
+		opts = &ResponseJSONOptions{}
+	}
+
+	var buf bytes.Buffer
+	encoder := jsonCodecOrDefault(opts.Codec).NewEncoder(&buf)
+	encoder.SetEscapeHTML(!opts.DisableHTMLEscape)
+	if opts.pretty(r) {
+		encoder.SetIndent("", opts.indent())
+	}
+	if err := encoder.Encode(v); err != nil {
 		return fmt.Errorf("response json marshal error: %w", err)
 	}
 
-	return ResponseJSONBytes(w, r, code, jsonBytes)
+	// Encode appends a newline which json.Marshal does not
+	jsonBytes := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+	return responseJSONBody(w, r, code, jsonBytes, opts)
 }
 
 func ResponseJSONBytes(w http.ResponseWriter, r *http.Request, code int, jsonBytes []byte) error {
+	return ResponseJSONBytesWithOptions(w, r, code, jsonBytes, nil)
+}
+
+func ResponseJSONBytesWithOptions(w http.ResponseWriter, r *http.Request, code int, jsonBytes []byte, opts *ResponseJSONOptions) error {
+	if opts == nil {
+		opts = &ResponseJSONOptions{}
+	}
+
+	if opts.pretty(r) {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, jsonBytes, "", opts.indent()); err != nil {
+			return fmt.Errorf("response json indent error: %w", err)
+		}
+		jsonBytes = buf.Bytes()
+	}
+
+	return responseJSONBody(w, r, code, jsonBytes, opts)
+}
+
+func responseJSONBody(w http.ResponseWriter, r *http.Request, code int, jsonBytes []byte, opts *ResponseJSONOptions) error {
+	if opts.Prefix != "" {
+		jsonBytes = append([]byte(opts.Prefix), jsonBytes...)
+	}
+
+	if !opts.DisableNoSniff {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+
+	if opts.Cache != nil {
+		if opts.Cache.CacheControl != "" {
+			w.Header().Set("Cache-Control", opts.Cache.CacheControl)
+		}
+		for _, vary := range opts.Cache.Vary {
+			w.Header().Add("Vary", vary)
+		}
+
+		if opts.Cache.ETag && code >= 200 && code <= 299 {
+			etag := JSONETag(jsonBytes)
+			w.Header().Set("ETag", etag)
+
+			if r != nil && (r.Method == http.MethodGet || r.Method == http.MethodHead) && ETagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return nil
+			}
+		}
+	}
+
 	w.Header().Set("Content-Length", strconv.Itoa(len(jsonBytes)))
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = "application/json; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
 
 	w.WriteHeader(code)
 
+	if r != nil && r.Method == http.MethodHead {
+		return nil
+	}
+
 	_, err := w.Write(jsonBytes)
 	return err
 }
+
+// JSONETag returns a strong ETag for the response body.
+func JSONETag(jsonBytes []byte) string {
+	h := fnv.New128a()
+	_, _ = h.Write(jsonBytes)
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// ETagMatches reports whether an If-None-Match header value matches etag using
+// the weak comparison function.
+func ETagMatches(ifNoneMatch string, etag string) bool {
+	ifNoneMatch = strings.TrimSpace(ifNoneMatch)
+	if ifNoneMatch == "" {
+		return false
+	}
+	if ifNoneMatch == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,175 @@
+package httputils_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+var _ = Describe("ResponseJSON", func() {
+	It("should write a JSON response", func() {
+		w := httptest.NewRecorder()
+		err := ResponseJSON(w, httptest.NewRequest("GET", "/", nil), http.StatusCreated, map[string]string{"foo": "bar"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/json; charset=utf-8"))
+		Expect(w.Header().Get("Content-Length")).To(Equal("13"))
+		Expect(w.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		Expect(w.Body.String()).To(Equal(`{"foo":"bar"}`))
+	})
+
+	It("should not write the body for HEAD requests", func() {
+		w := httptest.NewRecorder()
+		err := ResponseJSON(w, httptest.NewRequest("HEAD", "/", nil), http.StatusOK, map[string]string{"foo": "bar"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/json; charset=utf-8"))
+		Expect(w.Header().Get("Content-Length")).To(Equal("13"))
+		Expect(w.Body.Len()).To(Equal(0))
+	})
+
+	Describe("encoder options", func() {
+		v := map[string]string{"html": "<a>&</a>"}
+
+		It("should escape HTML by default", func() {
+			w := httptest.NewRecorder()
+			Expect(ResponseJSON(w, httptest.NewRequest("GET", "/", nil), http.StatusOK, v)).To(Succeed())
+			Expect(w.Body.String()).To(Equal(`{"html":"\u003ca\u003e\u0026\u003c/a\u003e"}`))
+		})
+
+		It("should disable HTML escaping", func() {
+			w := httptest.NewRecorder()
+			Expect(ResponseJSONWithOptions(w, httptest.NewRequest("GET", "/", nil), http.StatusOK, v, &ResponseJSONOptions{
+				DisableHTMLEscape: true,
+			})).To(Succeed())
+			Expect(w.Body.String()).To(Equal(`{"html":"<a>&</a>"}`))
+			Expect(w.Header().Get("Content-Length")).To(Equal("19"))
+		})
+
+		It("should pretty print if the pretty query parameter is enabled", func() {
+			for query, expected := range map[string]string{
+				"/?pretty":       "[\n  1\n]",
+				"/?pretty=true":  "[\n  1\n]",
+				"/?pretty=1":     "[\n  1\n]",
+				"/?pretty=false": "[1]",
+				"/?pretty=0":     "[1]",
+				"/?pretty=x":     "[1]",
+				"/":              "[1]",
+			} {
+				w := httptest.NewRecorder()
+				Expect(ResponseJSONWithOptions(w, httptest.NewRequest("GET", query, nil), http.StatusOK, []int{1}, &ResponseJSONOptions{
+					PrettyQuery: true,
+				})).To(Succeed())
+				Expect(w.Body.String()).To(Equal(expected), query)
+			}
+		})
+
+		It("should ignore the pretty query parameter by default", func() {
+			w := httptest.NewRecorder()
+			Expect(ResponseJSON(w, httptest.NewRequest("GET", "/?pretty", nil), http.StatusOK, []int{1})).To(Succeed())
+			Expect(w.Body.String()).To(Equal("[1]"))
+		})
+
+		It("should pretty print bytes if configured", func() {
+			w := httptest.NewRecorder()
+			Expect(ResponseJSONBytesWithOptions(w, httptest.NewRequest("GET", "/", nil), http.StatusOK, []byte(`{"a":[1,2]}`), &ResponseJSONOptions{
+				Pretty: true,
+				Indent: "\t",
+			})).To(Succeed())
+			Expect(w.Body.String()).To(Equal("{\n\t\"a\": [\n\t\t1,\n\t\t2\n\t]\n}"))
+			Expect(w.Header().Get("Content-Length")).To(Equal(strconv.Itoa(w.Body.Len())))
+		})
+
+		It("should write the JSON hijacking prefix", func() {
+			w := httptest.NewRecorder()
+			Expect(ResponseJSONWithOptions(w, httptest.NewRequest("GET", "/", nil), http.StatusOK, []int{1}, &ResponseJSONOptions{
+				Prefix: JSONHijackingPrefix,
+			})).To(Succeed())
+			Expect(w.Body.String()).To(Equal(")]}',\n[1]"))
+			Expect(w.Header().Get("Content-Length")).To(Equal("9"))
+		})
+
+		It("should not set nosniff if disabled", func() {
+			w := httptest.NewRecorder()
+			Expect(ResponseJSONWithOptions(w, httptest.NewRequest("GET", "/", nil), http.StatusOK, []int{1}, &ResponseJSONOptions{
+				DisableNoSniff: true,
+			})).To(Succeed())
+			Expect(w.Header().Get("X-Content-Type-Options")).To(BeEmpty())
+		})
+	})
+
+	Describe("CachePolicy", func() {
+		opts := &ResponseJSONOptions{
+			Cache: &CachePolicy{
+				ETag:         true,
+				CacheControl: "private, max-age=0",
+				Vary:         []string{"Authorization"},
+			},
+		}
+
+		It("should set ETag and caching headers", func() {
+			w := httptest.NewRecorder()
+			err := ResponseJSONWithOptions(w, httptest.NewRequest("GET", "/", nil), http.StatusOK, []int{1, 2, 3}, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("ETag")).To(Equal(JSONETag([]byte("[1,2,3]"))))
+			Expect(w.Header().Get("ETag")).To(MatchRegexp(`^"[0-9a-f]{32}"$`))
+			Expect(w.Header().Get("Cache-Control")).To(Equal("private, max-age=0"))
+			Expect(w.Header().Get("Vary")).To(Equal("Authorization"))
+			Expect(w.Body.String()).To(Equal("[1,2,3]"))
+		})
+
+		It("should respond with 304 if If-None-Match matches", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("If-None-Match", `"other", W/`+JSONETag([]byte("[1,2,3]")))
+
+			w := httptest.NewRecorder()
+			err := ResponseJSONBytesWithOptions(w, r, http.StatusOK, []byte("[1,2,3]"), opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(w.Code).To(Equal(http.StatusNotModified))
+			Expect(w.Header().Get("ETag")).To(Equal(JSONETag([]byte("[1,2,3]"))))
+			Expect(w.Header().Get("Cache-Control")).To(Equal("private, max-age=0"))
+			Expect(w.Header().Get("Content-Length")).To(BeEmpty())
+			Expect(w.Header().Get("Content-Type")).To(BeEmpty())
+			Expect(w.Body.Len()).To(Equal(0))
+		})
+
+		It("should respond with the body if If-None-Match does not match", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("If-None-Match", `"other"`)
+
+			w := httptest.NewRecorder()
+			err := ResponseJSONBytesWithOptions(w, r, http.StatusOK, []byte("[1,2,3]"), opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.String()).To(Equal("[1,2,3]"))
+		})
+
+		It("should ignore If-None-Match for unsafe methods and error responses", func() {
+			r := httptest.NewRequest("POST", "/", nil)
+			r.Header.Set("If-None-Match", "*")
+
+			w := httptest.NewRecorder()
+			Expect(ResponseJSONBytesWithOptions(w, r, http.StatusOK, []byte("[]"), opts)).To(Succeed())
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			r = httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("If-None-Match", "*")
+
+			w = httptest.NewRecorder()
+			Expect(ResponseJSONBytesWithOptions(w, r, http.StatusNotFound, []byte("{}"), opts)).To(Succeed())
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+			Expect(w.Header().Get("ETag")).To(BeEmpty())
+		})
+	})
+})
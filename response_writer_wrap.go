@@ -0,0 +1,309 @@
+package httputils
+
+import (
+	"io"
+	"net/http"
+)
+
+// optionalResponseWriter is implemented by response writer wrappers which
+// support every optional interface and delegate to the underlying writer.
+type optionalResponseWriter interface {
+	unwrapper
+	flusher
+	http.Hijacker
+	io.ReaderFrom
+	http.Pusher
+	http.CloseNotifier
+}
+
+// unwrapper is used by http.ResponseController to reach the underlying
+// writer's SetReadDeadline, SetWriteDeadline and EnableFullDuplex.
+type unwrapper interface {
+	http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+type flusher interface {
+	http.Flusher
+	FlushError() error
+}
+
+const (
+	flusherBit = 1 << iota
+	hijackerBit
+	readerFromBit
+	pusherBit
+	closeNotifierBit
+)
+
+// passThrough implements Unwrap and the optional interfaces which response
+// writer wrappers delegate to the underlying writer unchanged. It points to
+// the ResponseWriter field of the embedding wrapper, which the wrapper's
+// constructor must set.
+type passThrough struct {
+	w *http.ResponseWriter
+}
+
+// Unwrap returns the underlying writer. It is used by http.ResponseController.
+func (p passThrough) Unwrap() http.ResponseWriter {
+	return *p.w
+}
+
+func (p passThrough) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := (*p.w).(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
+
+func (p passThrough) CloseNotify() <-chan bool {
+	if closeNotifier, ok := (*p.w).(http.CloseNotifier); ok {
+		return closeNotifier.CloseNotify()
+	}
+
+	return make(chan bool)
+}
+
+// wrapResponseWriter returns w restricted to the optional interfaces
+// implemented by underlying, so that type assertions on the result behave the
+// same as they would on underlying.
+func wrapResponseWriter(w optionalResponseWriter, underlying http.ResponseWriter) http.ResponseWriter {
+	mask := 0
+	if _, ok := underlying.(http.Flusher); ok {
+		mask |= flusherBit
+	} else if _, ok := underlying.(interface{ FlushError() error }); ok {
+		mask |= flusherBit
+	}
+	if _, ok := underlying.(http.Hijacker); ok {
+		mask |= hijackerBit
+	}
+	if _, ok := underlying.(io.ReaderFrom); ok {
+		mask |= readerFromBit
+	}
+	if _, ok := underlying.(http.Pusher); ok {
+		mask |= pusherBit
+	}
+	if _, ok := underlying.(http.CloseNotifier); ok {
+		mask |= closeNotifierBit
+	}
+
+	switch mask {
+	case 0:
+		return struct {
+			unwrapper
+		}{w}
+	case flusherBit:
+		return struct {
+			unwrapper
+			flusher
+		}{w, w}
+	case hijackerBit:
+		return struct {
+			unwrapper
+			http.Hijacker
+		}{w, w}
+	case flusherBit | hijackerBit:
+		return struct {
+			unwrapper
+			flusher
+			http.Hijacker
+		}{w, w, w}
+	case readerFromBit:
+		return struct {
+			unwrapper
+			io.ReaderFrom
+		}{w, w}
+	case flusherBit | readerFromBit:
+		return struct {
+			unwrapper
+			flusher
+			io.ReaderFrom
+		}{w, w, w}
+	case hijackerBit | readerFromBit:
+		return struct {
+			unwrapper
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w}
+	case flusherBit | hijackerBit | readerFromBit:
+		return struct {
+			unwrapper
+			flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w, w}
+	case pusherBit:
+		return struct {
+			unwrapper
+			http.Pusher
+		}{w, w}
+	case flusherBit | pusherBit:
+		return struct {
+			unwrapper
+			flusher
+			http.Pusher
+		}{w, w, w}
+	case hijackerBit | pusherBit:
+		return struct {
+			unwrapper
+			http.Hijacker
+			http.Pusher
+		}{w, w, w}
+	case flusherBit | hijackerBit | pusherBit:
+		return struct {
+			unwrapper
+			flusher
+			http.Hijacker
+			http.Pusher
+		}{w, w, w, w}
+	case readerFromBit | pusherBit:
+		return struct {
+			unwrapper
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w}
+	case flusherBit | readerFromBit | pusherBit:
+		return struct {
+			unwrapper
+			flusher
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w, w}
+	case hijackerBit | readerFromBit | pusherBit:
+		return struct {
+			unwrapper
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w, w}
+	case flusherBit | hijackerBit | readerFromBit | pusherBit:
+		return struct {
+			unwrapper
+			flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w, w, w}
+	case closeNotifierBit:
+		return struct {
+			unwrapper
+			http.CloseNotifier
+		}{w, w}
+	case flusherBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			flusher
+			http.CloseNotifier
+		}{w, w, w}
+	case hijackerBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			http.Hijacker
+			http.CloseNotifier
+		}{w, w, w}
+	case flusherBit | hijackerBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			flusher
+			http.Hijacker
+			http.CloseNotifier
+		}{w, w, w, w}
+	case readerFromBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			io.ReaderFrom
+			http.CloseNotifier
+		}{w, w, w}
+	case flusherBit | readerFromBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			flusher
+			io.ReaderFrom
+			http.CloseNotifier
+		}{w, w, w, w}
+	case hijackerBit | readerFromBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			http.Hijacker
+			io.ReaderFrom
+			http.CloseNotifier
+		}{w, w, w, w}
+	case flusherBit | hijackerBit | readerFromBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.CloseNotifier
+		}{w, w, w, w, w}
+	case pusherBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w}
+	case flusherBit | pusherBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			flusher
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w}
+	case hijackerBit | pusherBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			http.Hijacker
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w}
+	case flusherBit | hijackerBit | pusherBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			flusher
+			http.Hijacker
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w, w}
+	case readerFromBit | pusherBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			io.ReaderFrom
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w}
+	case flusherBit | readerFromBit | pusherBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			flusher
+			io.ReaderFrom
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w, w}
+	case hijackerBit | readerFromBit | pusherBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w, w}
+	case flusherBit | hijackerBit | readerFromBit | pusherBit | closeNotifierBit:
+		return struct {
+			unwrapper
+			flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w, w, w}
+	}
+
+	panic("unreachable")
+}
+
+// writerOnly hides any optional interfaces of the wrapped writer. It is used
+// to fall back to io.Copy in ReadFrom without recursing into ReadFrom.
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,420 @@
+package httputils_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+type fullResponseWriter struct {
+	*httptest.ResponseRecorder
+	readFromCalled bool
+	pushed         []string
+	closeNotify    chan bool
+}
+
+func newFullResponseWriter() *fullResponseWriter {
+	return &fullResponseWriter{
+		ResponseRecorder: httptest.NewRecorder(),
+		closeNotify:      make(chan bool, 1),
+	}
+}
+
+func (w *fullResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, _ := net.Pipe()
+	return conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)), nil
+}
+
+func (w *fullResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.readFromCalled = true
+	return io.Copy(w.ResponseRecorder, src)
+}
+
+func (w *fullResponseWriter) Push(target string, opts *http.PushOptions) error {
+	w.pushed = append(w.pushed, target)
+	return nil
+}
+
+func (w *fullResponseWriter) CloseNotify() <-chan bool {
+	return w.closeNotify
+}
+
+const (
+	testFlusherBit = 1 << iota
+	testHijackerBit
+	testReaderFromBit
+	testPusherBit
+	testCloseNotifierBit
+)
+
+// restrictResponseWriter exposes only the optional interfaces selected by mask.
+func restrictResponseWriter(w *fullResponseWriter, mask int) http.ResponseWriter {
+	switch mask {
+	case 0:
+		return struct {
+			http.ResponseWriter
+		}{w}
+	case 1:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+		}{w, w}
+	case 2:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{w, w}
+	case 3:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{w, w, w}
+	case 4:
+		return struct {
+			http.ResponseWriter
+			io.ReaderFrom
+		}{w, w}
+	case 5:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{w, w, w}
+	case 6:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w}
+	case 7:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, w, w, w}
+	case 8:
+		return struct {
+			http.ResponseWriter
+			http.Pusher
+		}{w, w}
+	case 9:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Pusher
+		}{w, w, w}
+	case 10:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.Pusher
+		}{w, w, w}
+	case 11:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, w, w, w}
+	case 12:
+		return struct {
+			http.ResponseWriter
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w}
+	case 13:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w, w}
+	case 14:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w, w}
+	case 15:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+		}{w, w, w, w, w}
+	case 16:
+		return struct {
+			http.ResponseWriter
+			http.CloseNotifier
+		}{w, w}
+	case 17:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.CloseNotifier
+		}{w, w, w}
+	case 18:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.CloseNotifier
+		}{w, w, w}
+	case 19:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+		}{w, w, w, w}
+	case 20:
+		return struct {
+			http.ResponseWriter
+			io.ReaderFrom
+			http.CloseNotifier
+		}{w, w, w}
+	case 21:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			io.ReaderFrom
+			http.CloseNotifier
+		}{w, w, w, w}
+	case 22:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+			http.CloseNotifier
+		}{w, w, w, w}
+	case 23:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.CloseNotifier
+		}{w, w, w, w, w}
+	case 24:
+		return struct {
+			http.ResponseWriter
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w}
+	case 25:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w}
+	case 26:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w}
+	case 27:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w, w}
+	case 28:
+		return struct {
+			http.ResponseWriter
+			io.ReaderFrom
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w}
+	case 29:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			io.ReaderFrom
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w, w}
+	case 30:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w, w}
+	case 31:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			http.Pusher
+			http.CloseNotifier
+		}{w, w, w, w, w, w}
+	}
+	panic("invalid mask")
+}
+
+func expectOptionalInterfaces(w http.ResponseWriter, mask int) {
+	_, ok := w.(http.Flusher)
+	Expect(ok).To(Equal(mask&testFlusherBit != 0), "http.Flusher")
+	_, ok = w.(http.Hijacker)
+	Expect(ok).To(Equal(mask&testHijackerBit != 0), "http.Hijacker")
+	_, ok = w.(io.ReaderFrom)
+	Expect(ok).To(Equal(mask&testReaderFromBit != 0), "io.ReaderFrom")
+	_, ok = w.(http.Pusher)
+	Expect(ok).To(Equal(mask&testPusherBit != 0), "http.Pusher")
+	_, ok = w.(http.CloseNotifier)
+	Expect(ok).To(Equal(mask&testCloseNotifierBit != 0), "http.CloseNotifier")
+}
+
+var _ = Describe("ResponseWriterWrap", func() {
+	for mask := 0; mask < 32; mask++ {
+		mask := mask
+
+		It(fmt.Sprintf("should expose the same optional interfaces for combination %05b", mask), func() {
+			underlying := restrictResponseWriter(newFullResponseWriter(), mask)
+
+			expectOptionalInterfaces(NewCaptureResponseWriter(underlying).Wrap(), mask)
+			expectOptionalInterfaces(NewCallbackResponseWriter(underlying, func() {}).Wrap(), mask)
+			expectOptionalInterfaces(NewCaptureResponseWriter(NewCallbackResponseWriter(underlying, func() {}).Wrap()).Wrap(), mask)
+			expectOptionalInterfaces(NewBufferedResponseWriter(underlying, 0).Wrap(), mask)
+		})
+	}
+
+	It("should use the underlying ReaderFrom and count bytes", func() {
+		full := newFullResponseWriter()
+		cw := NewCaptureResponseWriter(full)
+
+		n, err := io.Copy(cw.Wrap(), struct{ io.Reader }{strings.NewReader("hello")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(int64(5)))
+		Expect(full.readFromCalled).To(BeTrue())
+		Expect(full.Body.String()).To(Equal("hello"))
+		Expect(cw.ResponseLength).To(Equal(int64(5)))
+		Expect(cw.HeaderWritten).To(BeTrue())
+	})
+
+	It("should fall back to Write in ReadFrom if the underlying writer is not a ReaderFrom", func() {
+		rec := httptest.NewRecorder()
+		cw := NewCaptureResponseWriter(rec)
+
+		n, err := cw.ReadFrom(strings.NewReader("hello"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(int64(5)))
+		Expect(rec.Body.String()).To(Equal("hello"))
+		Expect(cw.ResponseLength).To(Equal(int64(5)))
+	})
+
+	It("should call the callback before ReadFrom", func() {
+		full := newFullResponseWriter()
+		called := 0
+		cw := NewCallbackResponseWriter(full, func() {
+			called++
+			full.Header().Set("X-Callback", "1")
+		})
+
+		_, err := io.Copy(cw.Wrap(), struct{ io.Reader }{strings.NewReader("hello")})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(called).To(Equal(1))
+		Expect(full.readFromCalled).To(BeTrue())
+		Expect(full.Header().Get("X-Callback")).To(Equal("1"))
+	})
+
+	It("should delegate Push and CloseNotify", func() {
+		full := newFullResponseWriter()
+		w := NewCallbackResponseWriter(NewCaptureResponseWriter(full).Wrap(), func() {}).Wrap()
+
+		Expect(w.(http.Pusher).Push("/style.css", nil)).To(Succeed())
+		Expect(full.pushed).To(Equal([]string{"/style.css"}))
+
+		full.closeNotify <- true
+		Expect(<-w.(http.CloseNotifier).CloseNotify()).To(BeTrue())
+	})
+
+	It("should return ErrNotSupported from Push if the underlying writer is not a Pusher", func() {
+		cw := NewCaptureResponseWriter(httptest.NewRecorder())
+		Expect(cw.Push("/style.css", nil)).To(Equal(http.ErrNotSupported))
+	})
+
+	Describe("http.ResponseController", func() {
+		It("should set deadlines and enable full duplex through nested wrappers", func() {
+			errs := make(chan error, 5)
+			readErr := make(chan error, 1)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cw := NewCaptureResponseWriter(w)
+				w = NewCallbackResponseWriter(cw.Wrap(), func() {}).Wrap()
+				w = NewCaptureResponseWriter(w).Wrap()
+
+				rc := http.NewResponseController(w)
+				errs <- rc.SetWriteDeadline(time.Now().Add(time.Minute))
+				errs <- rc.EnableFullDuplex()
+				errs <- rc.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+
+				_, err := io.ReadAll(r.Body)
+				readErr <- err
+
+				w.WriteHeader(http.StatusOK)
+				errs <- rc.Flush()
+				_, err = w.Write([]byte("ok"))
+				errs <- err
+			}))
+			defer server.Close()
+
+			bodyReader, bodyWriter := io.Pipe()
+			defer bodyWriter.Close()
+
+			req, err := http.NewRequest("POST", server.URL, bodyReader)
+			Expect(err).NotTo(HaveOccurred())
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("ok"))
+
+			for i := 0; i < 5; i++ {
+				Expect(<-errs).NotTo(HaveOccurred())
+			}
+
+			var netErr net.Error
+			err = <-readErr
+			Expect(errors.As(err, &netErr)).To(BeTrue())
+			Expect(netErr.Timeout()).To(BeTrue())
+		})
+
+		It("should return ErrNotSupported from FlushError if the underlying writer cannot be flushed", func() {
+			underlying := restrictResponseWriter(newFullResponseWriter(), 0)
+
+			Expect(NewCaptureResponseWriter(underlying).FlushError()).To(MatchError(http.ErrNotSupported))
+			Expect(NewCallbackResponseWriter(underlying, func() {}).FlushError()).To(MatchError(http.ErrNotSupported))
+			Expect(http.NewResponseController(NewCaptureResponseWriter(underlying).Wrap()).Flush()).To(MatchError(http.ErrNotSupported))
+		})
+
+		It("should flush the underlying writer", func() {
+			full := newFullResponseWriter()
+
+			Expect(http.NewResponseController(NewCaptureResponseWriter(full).Wrap()).Flush()).To(Succeed())
+			Expect(full.Flushed).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,244 @@
+package httputils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrSignatureMissing = errors.New("signature missing")
+var ErrSignatureInvalid = errors.New("signature invalid")
+var ErrSignatureExpired = errors.New("signature expired")
+var ErrSignatureUnknownKey = errors.New("signature key unknown")
+var ErrSignatureMethodNotAllowed = errors.New("signature does not allow method")
+
+const (
+	signedURLExpiresParam     = "expires"
+	signedURLMethodsParam     = "methods"
+	signedURLFilenameParam    = "filename"
+	signedURLDispositionParam = "disposition"
+	signedURLKeyIDParam       = "kid"
+	signedURLSignatureParam   = "signature"
+)
+
+var signedURLParams = []string{
+	signedURLExpiresParam,
+	signedURLMethodsParam,
+	signedURLFilenameParam,
+	signedURLDispositionParam,
+	signedURLKeyIDParam,
+	signedURLSignatureParam,
+}
+
+// URLSigner generates and verifies HMAC-SHA256 signed URLs. The signature
+// covers the path and all query parameters but not the scheme and host, so
+// that URLs stay valid behind proxies.
+type URLSigner struct {
+	// Keys maps key IDs to secrets. URLs signed with any of the keys are
+	// accepted, so keys can be rotated by adding a new key, switching KeyID
+	// and removing the old key after the longest TTL.
+	Keys map[string][]byte
+	// KeyID is the key used to sign new URLs.
+	KeyID string
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+type SignedURLOptions struct {
+	TTL time.Duration
+	// Methods defaults to GET and HEAD.
+	Methods []string
+	// Filename and Disposition ("attachment" or "inline") are bound to the
+	// URL and applied by SignedURLClaims.SetContentDisposition.
+	Filename    string
+	Disposition string
+}
+
+type SignedURLClaims struct {
+	Path        string
+	Expires     time.Time
+	Methods     []string
+	Filename    string
+	Disposition string
+	KeyID       string
+}
+
+// SetContentDisposition sets the Content-Disposition header bound to the URL.
+// Attachments use ForceDownload.
+func (c *SignedURLClaims) SetContentDisposition(h http.Header) {
+	switch {
+	case c.Disposition == "inline" && c.Filename != "":
+		h.Set("Content-Disposition", contentDisposition("inline", c.Filename))
+	case c.Disposition == "inline":
+		h.Set("Content-Disposition", "inline")
+	case c.Filename != "":
+		ForceDownload(c.Filename, h)
+	case c.Disposition == "attachment":
+		h.Set("Content-Disposition", "attachment")
+	}
+}
+
+func (s *URLSigner) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Sign returns rawURL with the signature query parameters.
+func (s *URLSigner) Sign(rawURL string, opts *SignedURLOptions) (string, error) {
+	if opts == nil || opts.TTL <= 0 {
+		return "", errors.New("signed url TTL must be positive")
+	}
+	if opts.Disposition != "" && opts.Disposition != "attachment" && opts.Disposition != "inline" {
+		return "", errors.New("signed url disposition must be attachment or inline")
+	}
+
+	key, ok := s.Keys[s.KeyID]
+	if !ok {
+		return "", ErrSignatureUnknownKey
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	for _, param := range signedURLParams {
+		if _, ok := q[param]; ok {
+			return "", errors.New("signed url already contains parameter: " + param)
+		}
+	}
+
+	methods := []string{http.MethodGet, http.MethodHead}
+	if len(opts.Methods) > 0 {
+		methods = make([]string, len(opts.Methods))
+		for i, method := range opts.Methods {
+			methods[i] = strings.ToUpper(method)
+		}
+	}
+
+	q.Set(signedURLExpiresParam, strconv.FormatInt(s.now().Add(opts.TTL).Unix(), 10))
+	q.Set(signedURLMethodsParam, strings.Join(methods, ","))
+	if opts.Filename != "" {
+		q.Set(signedURLFilenameParam, opts.Filename)
+	}
+	if opts.Disposition != "" {
+		q.Set(signedURLDispositionParam, opts.Disposition)
+	}
+	q.Set(signedURLKeyIDParam, s.KeyID)
+	q.Set(signedURLSignatureParam, signURL(key, u.EscapedPath(), q))
+
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Verify checks the signature of the request URL and returns its claims. The
+// claims are also returned with ErrSignatureMethodNotAllowed so that the
+// allowed methods can be reported.
+func (s *URLSigner) Verify(r *http.Request) (*SignedURLClaims, error) {
+	q := r.URL.Query()
+
+	signatures := q[signedURLSignatureParam]
+	if len(signatures) == 0 {
+		return nil, ErrSignatureMissing
+	}
+	if len(signatures) > 1 {
+		return nil, ErrSignatureInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(signatures[0])
+	if err != nil {
+		return nil, ErrSignatureInvalid
+	}
+
+	keyID := q.Get(signedURLKeyIDParam)
+	key, ok := s.Keys[keyID]
+	if !ok {
+		return nil, ErrSignatureUnknownKey
+	}
+
+	q.Del(signedURLSignatureParam)
+	expected, _ := base64.RawURLEncoding.DecodeString(signURL(key, r.URL.EscapedPath(), q))
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrSignatureInvalid
+	}
+
+	// the parameters below are covered by the signature
+	expires, err := strconv.ParseInt(q.Get(signedURLExpiresParam), 10, 64)
+	if err != nil {
+		return nil, ErrSignatureInvalid
+	}
+
+	claims := &SignedURLClaims{
+		Path:        r.URL.Path,
+		Expires:     time.Unix(expires, 0),
+		Methods:     strings.Split(q.Get(signedURLMethodsParam), ","),
+		Filename:    q.Get(signedURLFilenameParam),
+		Disposition: q.Get(signedURLDispositionParam),
+		KeyID:       keyID,
+	}
+
+	if s.now().Unix() > expires {
+		return nil, ErrSignatureExpired
+	}
+
+	allowed := false
+	for _, method := range claims.Methods {
+		if method == r.Method {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return claims, ErrSignatureMethodNotAllowed
+	}
+
+	return claims, nil
+}
+
+// Middleware rejects requests without a valid signature with a problem
+// response and stores the claims in the request context (see
+// SignedURLClaimsFromContext).
+func (s *URLSigner) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := s.Verify(r)
+		if err != nil {
+			statusCode := http.StatusForbidden
+			if errors.Is(err, ErrSignatureMethodNotAllowed) {
+				statusCode = http.StatusMethodNotAllowed
+				if claims != nil {
+					w.Header().Set("Allow", strings.Join(claims.Methods, ", "))
+				}
+			}
+			_ = ResponseProblem(w, r, NewProblem(statusCode, err.Error()))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signedURLClaimsKey{}, claims)))
+	})
+}
+
+type signedURLClaimsKey struct{}
+
+func SignedURLClaimsFromContext(ctx context.Context) (*SignedURLClaims, bool) {
+	claims, ok := ctx.Value(signedURLClaimsKey{}).(*SignedURLClaims)
+	return claims, ok
+}
+
+// signURL signs the escaped path and the encoded query, which has sorted keys.
+func signURL(key []byte, escapedPath string, q url.Values) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(escapedPath))
+	_, _ = mac.Write([]byte{'?'})
+	_, _ = mac.Write([]byte(q.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,201 @@
+package httputils_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+var _ = Describe("URLSigner", func() {
+	var now time.Time
+	var signer *URLSigner
+
+	BeforeEach(func() {
+		now = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		signer = &URLSigner{
+			Keys:  map[string][]byte{"k1": []byte("secret1")},
+			KeyID: "k1",
+			Now: func() time.Time {
+				return now
+			},
+		}
+	})
+
+	sign := func(rawURL string, opts *SignedURLOptions) string {
+		signed, err := signer.Sign(rawURL, opts)
+		Expect(err).NotTo(HaveOccurred())
+		return signed
+	}
+
+	It("should sign and verify a URL", func() {
+		signed := sign("https://example.com/files/a%20b.txt?version=2", &SignedURLOptions{
+			TTL:         time.Hour,
+			Filename:    "a b.txt",
+			Disposition: "attachment",
+		})
+		Expect(signed).To(HavePrefix("https://example.com/files/a%20b.txt?"))
+
+		claims, err := signer.Verify(httptest.NewRequest("GET", signed, nil))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claims).To(Equal(&SignedURLClaims{
+			Path:        "/files/a b.txt",
+			Expires:     time.Unix(now.Add(time.Hour).Unix(), 0),
+			Methods:     []string{"GET", "HEAD"},
+			Filename:    "a b.txt",
+			Disposition: "attachment",
+			KeyID:       "k1",
+		}))
+
+		_, err = signer.Verify(httptest.NewRequest("HEAD", signed, nil))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject tampered URLs", func() {
+		signed := sign("/files/a.txt?version=2", &SignedURLOptions{TTL: time.Hour, Filename: "a.txt"})
+
+		for _, tampered := range []string{
+			strings.Replace(signed, "/files/a.txt", "/files/b.txt", 1),
+			strings.Replace(signed, "version=2", "version=3", 1),
+			strings.Replace(signed, "filename=a.txt", "filename=b.txt", 1),
+			signed + "&extra=1",
+			signed + "&signature=x",
+		} {
+			_, err := signer.Verify(httptest.NewRequest("GET", tampered, nil))
+			Expect(err).To(Equal(ErrSignatureInvalid), tampered)
+		}
+
+		u, _ := url.Parse(signed)
+		q := u.Query()
+		q.Set("signature", "not base64!")
+		u.RawQuery = q.Encode()
+		_, err := signer.Verify(httptest.NewRequest("GET", u.String(), nil))
+		Expect(err).To(Equal(ErrSignatureInvalid))
+
+		q.Del("signature")
+		u.RawQuery = q.Encode()
+		_, err = signer.Verify(httptest.NewRequest("GET", u.String(), nil))
+		Expect(err).To(Equal(ErrSignatureMissing))
+	})
+
+	It("should reject expired URLs", func() {
+		signed := sign("/a", &SignedURLOptions{TTL: time.Minute})
+
+		now = now.Add(time.Minute)
+		_, err := signer.Verify(httptest.NewRequest("GET", signed, nil))
+		Expect(err).NotTo(HaveOccurred())
+
+		now = now.Add(time.Second)
+		_, err = signer.Verify(httptest.NewRequest("GET", signed, nil))
+		Expect(err).To(Equal(ErrSignatureExpired))
+	})
+
+	It("should only allow the signed methods", func() {
+		signed := sign("/a", &SignedURLOptions{TTL: time.Minute, Methods: []string{"put"}})
+
+		_, err := signer.Verify(httptest.NewRequest("PUT", signed, nil))
+		Expect(err).NotTo(HaveOccurred())
+
+		claims, err := signer.Verify(httptest.NewRequest("GET", signed, nil))
+		Expect(err).To(Equal(ErrSignatureMethodNotAllowed))
+		Expect(claims.Methods).To(Equal([]string{"PUT"}))
+	})
+
+	It("should rotate keys", func() {
+		oldSigned := sign("/a", &SignedURLOptions{TTL: time.Minute})
+
+		signer.Keys["k2"] = []byte("secret2")
+		signer.KeyID = "k2"
+		newSigned := sign("/a", &SignedURLOptions{TTL: time.Minute})
+		Expect(newSigned).To(ContainSubstring("kid=k2"))
+
+		claims, err := signer.Verify(httptest.NewRequest("GET", oldSigned, nil))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claims.KeyID).To(Equal("k1"))
+
+		delete(signer.Keys, "k1")
+		_, err = signer.Verify(httptest.NewRequest("GET", oldSigned, nil))
+		Expect(err).To(Equal(ErrSignatureUnknownKey))
+		_, err = signer.Verify(httptest.NewRequest("GET", newSigned, nil))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = signer.Verify(httptest.NewRequest("GET", strings.Replace(newSigned, "kid=k2", "kid=k1", 1), nil))
+		Expect(err).To(Equal(ErrSignatureUnknownKey))
+	})
+
+	It("should validate sign options", func() {
+		_, err := signer.Sign("/a", nil)
+		Expect(err).To(HaveOccurred())
+		_, err = signer.Sign("/a", &SignedURLOptions{TTL: time.Minute, Disposition: "download"})
+		Expect(err).To(HaveOccurred())
+		_, err = signer.Sign("/a?expires=1", &SignedURLOptions{TTL: time.Minute})
+		Expect(err).To(MatchError("signed url already contains parameter: expires"))
+
+		signer.KeyID = "unknown"
+		_, err = signer.Sign("/a", &SignedURLOptions{TTL: time.Minute})
+		Expect(err).To(Equal(ErrSignatureUnknownKey))
+	})
+
+	Describe("Middleware", func() {
+		handler := func() http.Handler {
+			return signer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims, ok := SignedURLClaimsFromContext(r.Context())
+				Expect(ok).To(BeTrue())
+				claims.SetContentDisposition(w.Header())
+				_, _ = w.Write([]byte("content"))
+			}))
+		}
+
+		It("should pass the claims to the handler", func() {
+			signed := sign("/a", &SignedURLOptions{TTL: time.Minute, Filename: "a.txt"})
+
+			w := httptest.NewRecorder()
+			handler().ServeHTTP(w, httptest.NewRequest("GET", signed, nil))
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("application/force-download"))
+			Expect(w.Header().Get("Content-Disposition")).To(Equal(`attachment; filename="a.txt"; filename*=UTF-8''a.txt`))
+			Expect(w.Body.String()).To(Equal("content"))
+		})
+
+		It("should set inline disposition", func() {
+			signed := sign("/a", &SignedURLOptions{TTL: time.Minute, Filename: "a.txt", Disposition: "inline"})
+
+			w := httptest.NewRecorder()
+			handler().ServeHTTP(w, httptest.NewRequest("GET", signed, nil))
+			Expect(w.Header().Get("Content-Disposition")).To(Equal(`inline; filename="a.txt"; filename*=UTF-8''a.txt`))
+		})
+
+		It("should reject invalid signatures with a problem", func() {
+			w := httptest.NewRecorder()
+			handler().ServeHTTP(w, httptest.NewRequest("GET", "/a", nil))
+			Expect(w.Code).To(Equal(http.StatusForbidden))
+			Expect(w.Header().Get("Content-Type")).To(Equal(ProblemContentType))
+			Expect(w.Body.String()).To(Equal(`{"title":"Forbidden","status":403,"detail":"signature missing"}`))
+		})
+
+		It("should reject expired signatures", func() {
+			signed := sign("/a", &SignedURLOptions{TTL: time.Minute})
+			now = now.Add(time.Hour)
+
+			w := httptest.NewRecorder()
+			handler().ServeHTTP(w, httptest.NewRequest("GET", signed, nil))
+			Expect(w.Code).To(Equal(http.StatusForbidden))
+			Expect(w.Body.String()).To(ContainSubstring("signature expired"))
+		})
+
+		It("should reject methods which were not signed", func() {
+			signed := sign("/a", &SignedURLOptions{TTL: time.Minute})
+
+			w := httptest.NewRecorder()
+			handler().ServeHTTP(w, httptest.NewRequest("DELETE", signed, nil))
+			Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+			Expect(w.Header().Get("Allow")).To(Equal("GET, HEAD"))
+		})
+	})
+})
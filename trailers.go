@@ -0,0 +1,64 @@
+package httputils
+
+import (
+	"net/http"
+	"strings"
+)
+
+// AnnounceTrailers declares trailers in the Trailer header. It must be called
+// before the header is written.
+func AnnounceTrailers(h http.Header, names ...string) {
+	for _, name := range names {
+		h.Add("Trailer", http.CanonicalHeaderKey(name))
+	}
+}
+
+// SetTrailer sets a trailer value. It must be called after the body has been
+// written. Trailers which were not announced are set using http.TrailerPrefix.
+func SetTrailer(w http.ResponseWriter, name string, value string) {
+	h := w.Header()
+	name = http.CanonicalHeaderKey(name)
+
+	for _, declared := range declaredTrailers(h) {
+		if declared == name {
+			h.Set(name, value)
+			return
+		}
+	}
+
+	h.Set(http.TrailerPrefix+name, value)
+}
+
+func declaredTrailers(h http.Header) []string {
+	var names []string
+
+	for _, value := range h.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	return names
+}
+
+func trailers(h http.Header, declared []string) http.Header {
+	t := http.Header{}
+
+	for _, name := range declared {
+		if values, ok := h[name]; ok {
+			t[name] = append([]string(nil), values...)
+		}
+	}
+
+	for key, values := range h {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			name := http.CanonicalHeaderKey(key[len(http.TrailerPrefix):])
+			t[name] = append(t[name], values...)
+		}
+	}
+
+	return t
+}
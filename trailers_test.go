@@ -0,0 +1,89 @@
+package httputils_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+var _ = Describe("Trailers", func() {
+	It("should send announced and undeclared trailers through CaptureResponseWriter", func() {
+		captured := make(chan *CaptureResponseWriter, 1)
+
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			w := NewCaptureResponseWriter(rw)
+
+			AnnounceTrailers(w.Header(), "x-checksum")
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("X-After-Commit", "1")
+			_, _ = w.Write([]byte("hello"))
+
+			SetTrailer(w, "X-Checksum", "abc")
+			SetTrailer(w, "X-Undeclared", "def")
+
+			captured <- w
+		}))
+		defer server.Close()
+
+		res, err := http.Get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal("hello"))
+		Expect(res.Trailer.Get("X-Checksum")).To(Equal("abc"))
+		Expect(res.Trailer.Get("X-Undeclared")).To(Equal("def"))
+
+		w := <-captured
+		Expect(w.WrittenHeader.Get("Content-Type")).To(Equal("text/plain"))
+		Expect(w.WrittenHeader.Get("X-After-Commit")).To(BeEmpty())
+		Expect(w.DeclaredTrailers).To(Equal([]string{"X-Checksum"}))
+		Expect(w.Trailers()).To(Equal(http.Header{
+			"X-Checksum":   {"abc"},
+			"X-Undeclared": {"def"},
+		}))
+	})
+
+	It("should snapshot the header on first Write", func() {
+		w := NewCaptureResponseWriter(httptest.NewRecorder())
+
+		w.Header().Set("Trailer", "X-A, X-B")
+		w.Header().Set("X-Foo", "bar")
+		_, _ = w.Write([]byte("hello"))
+		w.Header().Set("X-Foo", "changed")
+
+		Expect(w.WrittenHeader.Get("X-Foo")).To(Equal("bar"))
+		Expect(w.DeclaredTrailers).To(Equal([]string{"X-A", "X-B"}))
+	})
+
+	It("should keep the header snapshot and the status code of a Flush", func() {
+		rec := httptest.NewRecorder()
+		w := NewCaptureResponseWriter(rec)
+		w.MisusePolicy = MisuseIgnore
+
+		w.Header().Set("X-Foo", "bar")
+		w.Flush()
+		w.Header().Set("X-Foo", "changed")
+		w.WriteHeader(http.StatusInternalServerError)
+
+		Expect(w.WrittenHeader.Get("X-Foo")).To(Equal("bar"))
+		Expect(w.StatusCode).To(Equal(rec.Code))
+		Expect(w.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("should return declared trailers before the header is written", func() {
+		w := NewCaptureResponseWriter(httptest.NewRecorder())
+
+		AnnounceTrailers(w.Header(), "X-A")
+		SetTrailer(w, "X-A", "1")
+
+		Expect(w.WrittenHeader).To(BeNil())
+		Expect(w.Trailers()).To(Equal(http.Header{"X-A": {"1"}}))
+	})
+})
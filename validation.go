@@ -0,0 +1,98 @@
+package httputils
+
+import (
+	"errors"
+	"strings"
+)
+
+// Validator is implemented by request values which validate themselves after
+// decoding.
+type Validator interface {
+	Validate() error
+}
+
+type ValidationError struct {
+	// Field is the path of the invalid field, e.g. "items[0].name".
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func NewValidationError(field string, code string, message string) *ValidationError {
+	return &ValidationError{
+		Field:   field,
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type ErrValidation struct {
+	Err error
+}
+
+func NewErrValidation(err error) *ErrValidation {
+	return &ErrValidation{Err: err}
+}
+
+func (e *ErrValidation) Error() string {
+	return "validation failed: " + e.Err.Error()
+}
+
+func (e *ErrValidation) Unwrap() error {
+	return e.Err
+}
+
+// ValidationErrorsFrom returns the structured validation errors of err. A
+// plain error returned by Validate is converted to a single ValidationError and
+// query or form field errors are converted to one ValidationError per field.
+func ValidationErrorsFrom(err error) ValidationErrors {
+	var validationErrors ValidationErrors
+	if errors.As(err, &validationErrors) {
+		return validationErrors
+	}
+	var validationError *ValidationError
+	if errors.As(err, &validationError) {
+		return ValidationErrors{validationError}
+	}
+	var errInvalidFields ErrInvalidFields
+	if errors.As(err, &errInvalidFields) {
+		validationErrors = make(ValidationErrors, len(errInvalidFields))
+		for i, fieldErr := range errInvalidFields {
+			validationErrors[i] = NewValidationError(fieldErr.Field, "invalid", fieldErr.Err.Error())
+		}
+		return validationErrors
+	}
+	var errValidation *ErrValidation
+	if errors.As(err, &errValidation) {
+		return ValidationErrors{NewValidationError("", "invalid", errValidation.Err.Error())}
+	}
+	return nil
+}
+
+func validate(v interface{}) error {
+	validator, ok := v.(Validator)
+	if !ok {
+		return nil
+	}
+	if err := validator.Validate(); err != nil {
+		return NewErrValidation(err)
+	}
+	return nil
+}
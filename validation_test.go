@@ -0,0 +1,111 @@
+package httputils_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+type validatedRequest struct {
+	Name  string `json:"name"`
+	Limit int    `json:"limit"`
+}
+
+func (r *validatedRequest) Validate() error {
+	var errs ValidationErrors
+	if r.Name == "" {
+		errs = append(errs, NewValidationError("name", "required", "name is required"))
+	}
+	if r.Limit < 0 {
+		errs = append(errs, NewValidationError("limit", "min", "limit must not be negative"))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+type plainValidatedRequest struct{}
+
+func (r *plainValidatedRequest) Validate() error {
+	return errors.New("always invalid")
+}
+
+func newJSONRequest(body string) *http.Request {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+var _ = Describe("Validation", func() {
+	It("should validate the decoded value", func() {
+		v := &validatedRequest{}
+		_, err := RequestJSONError(newJSONRequest(`{"limit": -1}`), v, 1024)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal("request json error: validation failed: name: name is required; limit: limit must not be negative"))
+
+		var errValidation *ErrValidation
+		Expect(errors.As(err, &errValidation)).To(BeTrue())
+		var errRequestJSON *ErrRequestJSON
+		Expect(errors.As(err, &errRequestJSON)).To(BeTrue())
+
+		Expect(ValidationErrorsFrom(err)).To(Equal(ValidationErrors{
+			{Field: "name", Code: "required", Message: "name is required"},
+			{Field: "limit", Code: "min", Message: "limit must not be negative"},
+		}))
+		Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("should return the value if it is valid", func() {
+		v := &validatedRequest{}
+		_, err := RequestJSONError(newJSONRequest(`{"name": "foo"}`), v, 1024)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v.Name).To(Equal("foo"))
+	})
+
+	It("should convert plain validation errors", func() {
+		_, err := RequestJSONError(newJSONRequest(`{}`), &plainValidatedRequest{}, 1024)
+		Expect(err).To(HaveOccurred())
+		Expect(ValidationErrorsFrom(err)).To(Equal(ValidationErrors{
+			{Field: "", Code: "invalid", Message: "always invalid"},
+		}))
+		Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("should map request errors to status codes", func() {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Content-Type", "text/plain")
+		_, err := RequestJSONError(r, nil, 1024)
+		Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusUnsupportedMediaType))
+
+		_, err = RequestJSONError(newJSONRequest(`{"name": "foo"}`), &validatedRequest{}, 1)
+		Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusRequestEntityTooLarge))
+
+		_, err = RequestJSONError(newJSONRequest(`invalid`), &validatedRequest{}, 1024)
+		Expect(RequestErrorStatusCode(err)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should write a problem response for validation errors", func() {
+		_, err := RequestJSONError(newJSONRequest(`{}`), &validatedRequest{}, 1024)
+		Expect(err).To(HaveOccurred())
+
+		w := httptest.NewRecorder()
+		Expect(ResponseRequestError(w, httptest.NewRequest("POST", "/", bytes.NewReader(nil)), err)).To(Succeed())
+
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/problem+json"))
+		Expect(w.Body.String()).To(MatchJSON(`{
+			"title": "Unprocessable Entity",
+			"status": 422,
+			"detail": "request json error: validation failed: name: name is required",
+			"errors": [{"field": "name", "code": "required", "message": "name is required"}]
+		}`))
+	})
+})
@@ -0,0 +1,653 @@
+package httputils
+
+import (
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/koofr/go-ioutils"
+)
+
+var ErrZipEntrySizeUnknown = errors.New("zip entry size unknown")
+var ErrZipEntrySizeMismatch = errors.New("zip entry size mismatch")
+var ErrZipEntryTooLarge = errors.New("zip entry of 4 GiB or more declared smaller")
+var ErrZipInvalidName = errors.New("invalid zip entry name")
+var ErrZipClosed = errors.New("zip writer closed")
+
+const (
+	zipLocalHeaderSignature    = 0x04034b50
+	zipCentralHeaderSignature  = 0x02014b50
+	zipDataDescriptorSignature = 0x08074b50
+	zipEndSignature            = 0x06054b50
+	zip64EndSignature          = 0x06064b50
+	zip64EndLocatorSignature   = 0x07064b50
+
+	zipLocalHeaderLen      = 30
+	zipCentralHeaderLen    = 46
+	zipDataDescriptorLen   = 16
+	zip64DataDescriptorLen = 24
+	zipEndLen              = 22
+	zip64EndLen            = 56
+	zip64EndLocatorLen     = 20
+
+	zipVersion20   = 20
+	zipVersion45   = 45
+	zipCreatorUnix = 3
+
+	zipFlagDataDescriptor = 0x8
+	zipFlagUTF8           = 0x800
+
+	zipMethodStore   = 0
+	zipMethodDeflate = 8
+
+	zip64ExtraID = 0x0001
+
+	zipUint16Max = 0xffff
+	zipUint32Max = 0xffffffff
+
+	zipModeFile = 0100644
+	zipModeDir  = 040755
+	zipAttrDir  = 0x10
+)
+
+// ZipEntry is a file or a directory (Name ending with "/") in a ZIP archive.
+type ZipEntry struct {
+	// Name is a relative slash-separated path.
+	Name string
+	// Size is the uncompressed size. It is required in store mode. In
+	// deflate mode it may be negative if unknown, but an entry which turns
+	// out to be 4 GiB or more after a smaller non-negative Size fails with
+	// ErrZipEntryTooLarge.
+	Size     int64
+	Modified time.Time
+	// Open is used by ResponseZip to open the entry content. It is not
+	// called for directories.
+	Open func() (io.ReadCloser, error)
+}
+
+func (e *ZipEntry) isDir() bool {
+	return strings.HasSuffix(e.Name, "/")
+}
+
+// ErrZipEntry is returned when the content of an entry could not be read. The
+// entry is still written (padded with zeros to its size in store mode) with
+// an invalid CRC-32 so that extracting it fails, while the rest of the
+// archive stays valid.
+type ErrZipEntry struct {
+	Name string
+	Err  error
+}
+
+func NewErrZipEntry(name string, err error) *ErrZipEntry {
+	return &ErrZipEntry{
+		Name: name,
+		Err:  err,
+	}
+}
+
+func (e *ErrZipEntry) Error() string {
+	return fmt.Sprintf("zip entry %s: %s", e.Name, e.Err.Error())
+}
+
+func (e *ErrZipEntry) Unwrap() error {
+	return e.Err
+}
+
+type ErrZipEntries []*ErrZipEntry
+
+func (e ErrZipEntries) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type zipRecord struct {
+	name             string
+	flags            uint16
+	method           uint16
+	modTime          uint16
+	modDate          uint16
+	crc              uint32
+	compressedSize   int64
+	uncompressedSize int64
+	offset           int64
+	dir              bool
+	// zip64 is set if the local header has a ZIP64 extra field, in which
+	// case the data descriptor has 8 byte sizes.
+	zip64 bool
+}
+
+func (r *zipRecord) isZip64Size() bool {
+	return r.compressedSize >= zipUint32Max || r.uncompressedSize >= zipUint32Max
+}
+
+func (r *zipRecord) isZip64Offset() bool {
+	return r.offset >= zipUint32Max
+}
+
+// ZipStreamWriter writes a ZIP archive to a stream without seeking. Entry
+// sizes and CRC-32 checksums are written in data descriptors after the
+// content. ZIP64 records are written only where needed, so that archives of
+// small files stay readable by old tools.
+type ZipStreamWriter struct {
+	// Store disables compression. Entry sizes must be known in advance,
+	// which makes the archive size predictable (see ZipStoreSize).
+	Store bool
+
+	out     *zipOutput
+	records []*zipRecord
+	closed  bool
+}
+
+func NewZipStreamWriter(w io.Writer, store bool) *ZipStreamWriter {
+	return &ZipStreamWriter{
+		Store: store,
+
+		out: &zipOutput{w: w},
+	}
+}
+
+// WriteEntry writes entry with the content read from r. If reading r fails
+// (or, in store mode, r does not contain exactly entry.Size bytes),
+// WriteEntry returns *ErrZipEntry and the writer can still be used. Any other
+// error means that the archive is broken.
+func (z *ZipStreamWriter) WriteEntry(entry *ZipEntry, r io.Reader) error {
+	if z.closed {
+		return ErrZipClosed
+	}
+	if z.out.err != nil {
+		return z.out.err
+	}
+	if err := validateZipName(entry.Name); err != nil {
+		return err
+	}
+	if z.Store && entry.Size < 0 && !entry.isDir() {
+		return ErrZipEntrySizeUnknown
+	}
+
+	rec := newZipRecord(entry, z.out.offset, z.Store)
+
+	if _, err := z.out.Write(zipLocalHeader(rec)); err != nil {
+		return err
+	}
+
+	var readErr error
+	if !rec.dir {
+		readErr = z.writeEntryData(rec, entry.Size, r)
+		if z.out.err != nil {
+			return z.out.err
+		}
+		if rec.isZip64Size() && !rec.zip64 {
+			// the sizes do not fit the data descriptor announced by the
+			// local header
+			z.out.err = ErrZipEntryTooLarge
+			return z.out.err
+		}
+	}
+
+	if _, err := z.out.Write(zipDataDescriptor(rec)); err != nil {
+		return err
+	}
+
+	z.records = append(z.records, rec)
+
+	if readErr != nil {
+		return NewErrZipEntry(entry.Name, readErr)
+	}
+
+	return nil
+}
+
+func (z *ZipStreamWriter) writeEntryData(rec *zipRecord, size int64, r io.Reader) error {
+	crc := crc32.NewIEEE()
+	src := &zipEntryReader{r: io.TeeReader(r, crc)}
+
+	start := z.out.offset
+
+	var readErr error
+
+	if rec.method == zipMethodStore {
+		n, _ := io.Copy(z.out, io.LimitReader(src, size))
+		if z.out.err != nil {
+			return nil
+		}
+		readErr = src.err
+		if readErr == nil && n < size {
+			readErr = io.ErrUnexpectedEOF
+		}
+		if readErr == nil {
+			// the content must not be longer than the declared size
+			if m, _ := io.CopyN(io.Discard, r, 1); m > 0 {
+				readErr = ErrZipEntrySizeMismatch
+			}
+		}
+		if n < size {
+			// keep the declared size so that the offsets (and the
+			// Content-Length) stay correct
+			if _, err := io.CopyN(io.MultiWriter(z.out, crc), zeroReader{}, size-n); err != nil {
+				return nil
+			}
+		}
+		rec.uncompressedSize = size
+	} else {
+		fw, _ := flate.NewWriter(z.out, flate.DefaultCompression)
+		n, _ := io.Copy(fw, src)
+		if z.out.err != nil {
+			return nil
+		}
+		if err := fw.Close(); err != nil {
+			return nil
+		}
+		readErr = src.err
+		rec.uncompressedSize = n
+	}
+
+	rec.compressedSize = z.out.offset - start
+	rec.crc = crc.Sum32()
+	if readErr != nil {
+		// deliberately invalid so that extracting the entry fails
+		rec.crc = ^rec.crc
+	}
+
+	return readErr
+}
+
+// Close writes the central directory. It does not close the underlying
+// writer.
+func (z *ZipStreamWriter) Close() error {
+	if z.closed {
+		return ErrZipClosed
+	}
+	z.closed = true
+
+	if z.out.err != nil {
+		return z.out.err
+	}
+
+	centralStart := z.out.offset
+	for _, rec := range z.records {
+		if _, err := z.out.Write(zipCentralHeader(rec)); err != nil {
+			return err
+		}
+	}
+
+	_, err := z.out.Write(zipEnd(len(z.records), centralStart, z.out.offset-centralStart))
+	return err
+}
+
+// ZipStoreSize returns the exact size of a store mode archive of entries.
+func ZipStoreSize(entries []*ZipEntry) (int64, error) {
+	var offset int64
+	records := make([]*zipRecord, 0, len(entries))
+
+	for _, entry := range entries {
+		if err := validateZipName(entry.Name); err != nil {
+			return 0, err
+		}
+		rec := newZipRecord(entry, offset, true)
+		if !rec.dir {
+			if entry.Size < 0 {
+				return 0, ErrZipEntrySizeUnknown
+			}
+			rec.compressedSize = entry.Size
+			rec.uncompressedSize = entry.Size
+		}
+		offset += int64(len(zipLocalHeader(rec))) + rec.compressedSize + int64(len(zipDataDescriptor(rec)))
+		records = append(records, rec)
+	}
+
+	centralStart := offset
+	for _, rec := range records {
+		offset += int64(len(zipCentralHeader(rec)))
+	}
+
+	return offset + int64(len(zipEnd(len(records), centralStart, offset-centralStart))), nil
+}
+
+// ResponseZip writes entries as a ZIP archive download named filename (see
+// ForceDownload). In store mode Content-Length is set. Entries which cannot
+// be opened or read are written as invalid entries (see ErrZipEntry) and
+// returned as ErrZipEntries after the whole archive was written.
+func ResponseZip(w http.ResponseWriter, r *http.Request, filename string, entries []*ZipEntry, store bool) error {
+	if store {
+		size, err := ZipStoreSize(entries)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	} else {
+		for _, entry := range entries {
+			if err := validateZipName(entry.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	ForceDownload(filename, w.Header())
+
+	w.WriteHeader(http.StatusOK)
+
+	if r != nil && r.Method == http.MethodHead {
+		return nil
+	}
+
+	z := NewZipStreamWriter(w, store)
+
+	var entryErrs ErrZipEntries
+
+	for _, entry := range entries {
+		err := writeZipEntry(z, entry)
+		var entryErr *ErrZipEntry
+		if errors.As(err, &entryErr) {
+			entryErrs = append(entryErrs, entryErr)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if err := z.Close(); err != nil {
+		return err
+	}
+
+	if len(entryErrs) > 0 {
+		return entryErrs
+	}
+
+	return nil
+}
+
+func writeZipEntry(z *ZipStreamWriter, entry *ZipEntry) error {
+	if entry.isDir() || entry.Open == nil {
+		return z.WriteEntry(entry, strings.NewReader(""))
+	}
+
+	reader, err := entry.Open()
+	if err != nil {
+		return z.WriteEntry(entry, ioutils.NewErrorReader(err))
+	}
+	defer reader.Close()
+
+	return z.WriteEntry(entry, reader)
+}
+
+func newZipRecord(entry *ZipEntry, offset int64, store bool) *zipRecord {
+	modTime, modDate := zipDOSTime(entry.Modified)
+
+	rec := &zipRecord{
+		name:    entry.Name,
+		flags:   zipFlagDataDescriptor,
+		method:  zipMethodDeflate,
+		modTime: modTime,
+		modDate: modDate,
+		offset:  offset,
+		dir:     entry.isDir(),
+	}
+
+	if store || rec.dir {
+		rec.method = zipMethodStore
+	}
+
+	switch {
+	case rec.dir:
+	case rec.method == zipMethodStore:
+		rec.zip64 = entry.Size >= zipUint32Max
+	case entry.Size < 0:
+		// the sizes are only known after compression
+		rec.zip64 = true
+	default:
+		rec.zip64 = zipDeflateBound(entry.Size) >= zipUint32Max
+	}
+
+	for i := 0; i < len(entry.Name); i++ {
+		if entry.Name[i] >= utf8.RuneSelf {
+			rec.flags |= zipFlagUTF8
+			break
+		}
+	}
+
+	return rec
+}
+
+// zipDeflateBound returns an upper bound of the deflated length of size bytes.
+// Incompressible data is written in stored blocks of at most 65535 bytes with
+// a 5 byte header.
+func zipDeflateBound(size int64) int64 {
+	return size + size/4096 + 1024
+}
+
+// zipLocalHeader returns the local file header of rec. Readers expect the 24
+// byte ZIP64 data descriptor only if the local header has a ZIP64 extra field,
+// so it is written for entries which may reach 4 GiB (see rec.zip64). Its
+// sizes are zero as the actual values follow in the data descriptor.
+func zipLocalHeader(rec *zipRecord) []byte {
+	version := uint16(zipVersion20)
+	var sizeField uint32
+	var extra []byte
+	if rec.zip64 {
+		version = zipVersion45
+		sizeField = zipUint32Max
+		extra = binary.LittleEndian.AppendUint16(extra, zip64ExtraID)
+		extra = binary.LittleEndian.AppendUint16(extra, 16)
+		extra = binary.LittleEndian.AppendUint64(extra, 0) // uncompressed size
+		extra = binary.LittleEndian.AppendUint64(extra, 0) // compressed size
+	}
+
+	b := make([]byte, 0, zipLocalHeaderLen+len(rec.name)+len(extra))
+	b = binary.LittleEndian.AppendUint32(b, zipLocalHeaderSignature)
+	b = binary.LittleEndian.AppendUint16(b, version)
+	b = binary.LittleEndian.AppendUint16(b, rec.flags)
+	b = binary.LittleEndian.AppendUint16(b, rec.method)
+	b = binary.LittleEndian.AppendUint16(b, rec.modTime)
+	b = binary.LittleEndian.AppendUint16(b, rec.modDate)
+	// crc-32 and sizes are written in the data descriptor
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, sizeField)
+	b = binary.LittleEndian.AppendUint32(b, sizeField)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(rec.name)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(extra)))
+	b = append(b, rec.name...)
+	b = append(b, extra...)
+	return b
+}
+
+func zipDataDescriptor(rec *zipRecord) []byte {
+	if rec.zip64 {
+		b := make([]byte, 0, zip64DataDescriptorLen)
+		b = binary.LittleEndian.AppendUint32(b, zipDataDescriptorSignature)
+		b = binary.LittleEndian.AppendUint32(b, rec.crc)
+		b = binary.LittleEndian.AppendUint64(b, uint64(rec.compressedSize))
+		b = binary.LittleEndian.AppendUint64(b, uint64(rec.uncompressedSize))
+		return b
+	}
+
+	b := make([]byte, 0, zipDataDescriptorLen)
+	b = binary.LittleEndian.AppendUint32(b, zipDataDescriptorSignature)
+	b = binary.LittleEndian.AppendUint32(b, rec.crc)
+	b = binary.LittleEndian.AppendUint32(b, uint32(rec.compressedSize))
+	b = binary.LittleEndian.AppendUint32(b, uint32(rec.uncompressedSize))
+	return b
+}
+
+func zipCentralHeader(rec *zipRecord) []byte {
+	version := uint16(zipVersion20)
+	compressedSize := uint32(rec.compressedSize)
+	uncompressedSize := uint32(rec.uncompressedSize)
+	offset := uint32(rec.offset)
+
+	// the zip64 extra field only contains the values which do not fit
+	var extra []byte
+	if rec.isZip64Size() || rec.isZip64Offset() {
+		version = zipVersion45
+		var values []byte
+		if rec.isZip64Size() {
+			values = binary.LittleEndian.AppendUint64(values, uint64(rec.uncompressedSize))
+			values = binary.LittleEndian.AppendUint64(values, uint64(rec.compressedSize))
+			compressedSize = zipUint32Max
+			uncompressedSize = zipUint32Max
+		}
+		if rec.isZip64Offset() {
+			values = binary.LittleEndian.AppendUint64(values, uint64(rec.offset))
+			offset = zipUint32Max
+		}
+		extra = binary.LittleEndian.AppendUint16(extra, zip64ExtraID)
+		extra = binary.LittleEndian.AppendUint16(extra, uint16(len(values)))
+		extra = append(extra, values...)
+	}
+
+	externalAttrs := uint32(zipModeFile) << 16
+	if rec.dir {
+		externalAttrs = uint32(zipModeDir)<<16 | zipAttrDir
+	}
+
+	b := make([]byte, 0, zipCentralHeaderLen+len(rec.name)+len(extra))
+	b = binary.LittleEndian.AppendUint32(b, zipCentralHeaderSignature)
+	b = binary.LittleEndian.AppendUint16(b, zipCreatorUnix<<8|zipVersion45)
+	b = binary.LittleEndian.AppendUint16(b, version)
+	b = binary.LittleEndian.AppendUint16(b, rec.flags)
+	b = binary.LittleEndian.AppendUint16(b, rec.method)
+	b = binary.LittleEndian.AppendUint16(b, rec.modTime)
+	b = binary.LittleEndian.AppendUint16(b, rec.modDate)
+	b = binary.LittleEndian.AppendUint32(b, rec.crc)
+	b = binary.LittleEndian.AppendUint32(b, compressedSize)
+	b = binary.LittleEndian.AppendUint32(b, uncompressedSize)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(rec.name)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(extra)))
+	b = binary.LittleEndian.AppendUint16(b, 0) // comment length
+	b = binary.LittleEndian.AppendUint16(b, 0) // disk number start
+	b = binary.LittleEndian.AppendUint16(b, 0) // internal attributes
+	b = binary.LittleEndian.AppendUint32(b, externalAttrs)
+	b = binary.LittleEndian.AppendUint32(b, offset)
+	b = append(b, rec.name...)
+	b = append(b, extra...)
+	return b
+}
+
+func zipEnd(count int, centralStart int64, centralSize int64) []byte {
+	zip64 := count >= zipUint16Max || centralStart >= zipUint32Max || centralSize >= zipUint32Max
+
+	b := make([]byte, 0, zip64EndLen+zip64EndLocatorLen+zipEndLen)
+
+	if zip64 {
+		end64Offset := centralStart + centralSize
+
+		b = binary.LittleEndian.AppendUint32(b, zip64EndSignature)
+		b = binary.LittleEndian.AppendUint64(b, zip64EndLen-12) // size of the remaining record
+		b = binary.LittleEndian.AppendUint16(b, zipCreatorUnix<<8|zipVersion45)
+		b = binary.LittleEndian.AppendUint16(b, zipVersion45)
+		b = binary.LittleEndian.AppendUint32(b, 0) // number of this disk
+		b = binary.LittleEndian.AppendUint32(b, 0) // disk with the central directory
+		b = binary.LittleEndian.AppendUint64(b, uint64(count))
+		b = binary.LittleEndian.AppendUint64(b, uint64(count))
+		b = binary.LittleEndian.AppendUint64(b, uint64(centralSize))
+		b = binary.LittleEndian.AppendUint64(b, uint64(centralStart))
+
+		b = binary.LittleEndian.AppendUint32(b, zip64EndLocatorSignature)
+		b = binary.LittleEndian.AppendUint32(b, 0) // disk with the zip64 end record
+		b = binary.LittleEndian.AppendUint64(b, uint64(end64Offset))
+		b = binary.LittleEndian.AppendUint32(b, 1) // total number of disks
+
+		if count > zipUint16Max {
+			count = zipUint16Max
+		}
+		if centralStart > zipUint32Max {
+			centralStart = zipUint32Max
+		}
+		if centralSize > zipUint32Max {
+			centralSize = zipUint32Max
+		}
+	}
+
+	b = binary.LittleEndian.AppendUint32(b, zipEndSignature)
+	b = binary.LittleEndian.AppendUint16(b, 0) // number of this disk
+	b = binary.LittleEndian.AppendUint16(b, 0) // disk with the central directory
+	b = binary.LittleEndian.AppendUint16(b, uint16(count))
+	b = binary.LittleEndian.AppendUint16(b, uint16(count))
+	b = binary.LittleEndian.AppendUint32(b, uint32(centralSize))
+	b = binary.LittleEndian.AppendUint32(b, uint32(centralStart))
+	b = binary.LittleEndian.AppendUint16(b, 0) // comment length
+	return b
+}
+
+// zipDOSTime converts t to MS-DOS date and time, which can only represent
+// years 1980 to 2107 with a 2 second resolution.
+func zipDOSTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	} else if t.Year() > 2107 {
+		t = time.Date(2107, 12, 31, 23, 59, 58, 0, time.UTC)
+	}
+
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+
+	return tm, date
+}
+
+func validateZipName(name string) error {
+	if name == "" || len(name) > zipUint16Max || !utf8.ValidString(name) {
+		return ErrZipInvalidName
+	}
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return ErrZipInvalidName
+	}
+	for _, part := range strings.Split(strings.TrimSuffix(name, "/"), "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrZipInvalidName
+		}
+	}
+	return nil
+}
+
+// zipOutput counts the written bytes and remembers the first write error,
+// after which the archive is broken.
+type zipOutput struct {
+	w      io.Writer
+	offset int64
+	err    error
+}
+
+func (o *zipOutput) Write(b []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	n, err := o.w.Write(b)
+	o.offset += int64(n)
+	if err != nil {
+		o.err = err
+	}
+	return n, err
+}
+
+// zipEntryReader records read errors so that they can be told apart from
+// write errors.
+type zipEntryReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *zipEntryReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
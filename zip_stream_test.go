@@ -0,0 +1,389 @@
+package httputils_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koofr/go-ioutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	. "github.com/koofr/go-httputils"
+)
+
+func newZipEntry(name string, content string) *ZipEntry {
+	return &ZipEntry{
+		Name:     name,
+		Size:     int64(len(content)),
+		Modified: time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC),
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(content)), nil
+		},
+	}
+}
+
+func readZip(b []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	Expect(err).NotTo(HaveOccurred())
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		Expect(err).NotTo(HaveOccurred())
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			files[f.Name] = "error: " + err.Error()
+		} else {
+			files[f.Name] = string(data)
+		}
+		rc.Close()
+	}
+	return files
+}
+
+// sparseZipBuffer stores the written bytes but keeps only the length of
+// zero-filled writes so that huge archives of zeros can be tested.
+type sparseZipBuffer struct {
+	chunks []sparseZipChunk
+	size   int64
+}
+
+type sparseZipChunk struct {
+	offset int64
+	data   []byte
+	zeros  int64
+}
+
+func (b *sparseZipBuffer) Write(p []byte) (int, error) {
+	if len(p) >= 4096 && bytes.Count(p, []byte{0}) == len(p) {
+		if last := len(b.chunks) - 1; last >= 0 && b.chunks[last].data == nil {
+			b.chunks[last].zeros += int64(len(p))
+		} else {
+			b.chunks = append(b.chunks, sparseZipChunk{offset: b.size, zeros: int64(len(p))})
+		}
+	} else {
+		b.chunks = append(b.chunks, sparseZipChunk{offset: b.size, data: append([]byte(nil), p...)})
+	}
+	b.size += int64(len(p))
+	return len(p), nil
+}
+
+func (b *sparseZipBuffer) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, c := range b.chunks {
+		length := c.zeros
+		if c.data != nil {
+			length = int64(len(c.data))
+		}
+		for n < len(p) && off+int64(n) >= c.offset && off+int64(n) < c.offset+length {
+			pos := off + int64(n) - c.offset
+			if c.data != nil {
+				n += copy(p[n:], c.data[pos:])
+			} else {
+				m := int(min(int64(len(p)-n), length-pos))
+				clear(p[n : n+m])
+				n += m
+			}
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+var _ = Describe("ZipStreamWriter", func() {
+	It("should write a deflated archive", func() {
+		var buf bytes.Buffer
+		z := NewZipStreamWriter(&buf, false)
+		Expect(z.WriteEntry(newZipEntry("a.txt", "hello hello hello"), strings.NewReader("hello hello hello"))).To(Succeed())
+		Expect(z.WriteEntry(&ZipEntry{Name: "dir/"}, nil)).To(Succeed())
+		Expect(z.WriteEntry(&ZipEntry{Name: "dir/b.txt", Size: -1}, strings.NewReader("b"))).To(Succeed())
+		Expect(z.Close()).To(Succeed())
+
+		Expect(readZip(buf.Bytes())).To(Equal(map[string]string{
+			"a.txt":     "hello hello hello",
+			"dir/":      "",
+			"dir/b.txt": "b",
+		}))
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zr.File[0].Method).To(Equal(zip.Deflate))
+		Expect(zr.File[0].Modified.UTC()).To(Equal(time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)))
+		Expect(zr.File[1].Mode().IsDir()).To(BeTrue())
+	})
+
+	It("should write zip64 data descriptors for deflated entries of unknown size", func() {
+		var buf bytes.Buffer
+		z := NewZipStreamWriter(&buf, false)
+		Expect(z.WriteEntry(&ZipEntry{Name: "a.txt", Size: -1}, strings.NewReader("hello hello hello"))).To(Succeed())
+		Expect(z.WriteEntry(newZipEntry("b.txt", "b"), strings.NewReader("b"))).To(Succeed())
+		Expect(z.Close()).To(Succeed())
+
+		data := buf.Bytes()
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		Expect(err).NotTo(HaveOccurred())
+
+		// local header with a zip64 extra field followed by a 24 byte data
+		// descriptor
+		Expect(binary.LittleEndian.Uint16(data[28:])).To(Equal(uint16(20)))
+		Expect(binary.LittleEndian.Uint16(data[30+len("a.txt"):])).To(Equal(uint16(0x0001)))
+		offset, err := zr.File[0].DataOffset()
+		Expect(err).NotTo(HaveOccurred())
+		descriptor := data[offset+int64(zr.File[0].CompressedSize64):]
+		Expect(binary.LittleEndian.Uint32(descriptor)).To(Equal(uint32(0x08074b50)))
+		Expect(binary.LittleEndian.Uint64(descriptor[8:])).To(Equal(zr.File[0].CompressedSize64))
+		Expect(binary.LittleEndian.Uint64(descriptor[16:])).To(Equal(uint64(17)))
+		Expect(binary.LittleEndian.Uint32(descriptor[24:])).To(Equal(uint32(0x04034b50)))
+
+		// entries of known small size keep the 16 byte data descriptor
+		Expect(binary.LittleEndian.Uint16(descriptor[24+28:])).To(Equal(uint16(0)))
+
+		Expect(readZip(data)).To(Equal(map[string]string{
+			"a.txt": "hello hello hello",
+			"b.txt": "b",
+		}))
+	})
+
+	It("should set the UTF-8 flag for non-ASCII names", func() {
+		var buf bytes.Buffer
+		z := NewZipStreamWriter(&buf, true)
+		Expect(z.WriteEntry(newZipEntry("čšž.txt", "x"), strings.NewReader("x"))).To(Succeed())
+		Expect(z.WriteEntry(newZipEntry("ascii.txt", "y"), strings.NewReader("y"))).To(Succeed())
+		Expect(z.Close()).To(Succeed())
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zr.File[0].Name).To(Equal("čšž.txt"))
+		Expect(zr.File[0].Flags & 0x800).To(Equal(uint16(0x800)))
+		Expect(zr.File[1].Flags & 0x800).To(Equal(uint16(0)))
+	})
+
+	It("should write invalid entries for read errors", func() {
+		var buf bytes.Buffer
+		z := NewZipStreamWriter(&buf, true)
+		Expect(z.WriteEntry(newZipEntry("a.txt", "aaa"), strings.NewReader("aaa"))).To(Succeed())
+
+		err := z.WriteEntry(newZipEntry("b.txt", "bbbbbb"), io.MultiReader(strings.NewReader("bb"), ioutils.NewErrorReader(errors.New("read error"))))
+		var errZipEntry *ErrZipEntry
+		Expect(errors.As(err, &errZipEntry)).To(BeTrue())
+		Expect(err).To(MatchError("zip entry b.txt: read error"))
+
+		Expect(z.WriteEntry(newZipEntry("c.txt", "cccc"), strings.NewReader("cc"))).To(MatchError("zip entry c.txt: unexpected EOF"))
+		Expect(z.WriteEntry(newZipEntry("d.txt", "d"), strings.NewReader("dd"))).To(MatchError("zip entry d.txt: zip entry size mismatch"))
+		Expect(z.WriteEntry(newZipEntry("e.txt", "eee"), strings.NewReader("eee"))).To(Succeed())
+		Expect(z.Close()).To(Succeed())
+
+		Expect(readZip(buf.Bytes())).To(Equal(map[string]string{
+			"a.txt": "aaa",
+			"b.txt": "error: zip: checksum error",
+			"c.txt": "error: zip: checksum error",
+			"d.txt": "error: zip: checksum error",
+			"e.txt": "eee",
+		}))
+	})
+
+	It("should write invalid entries for read errors in deflate mode", func() {
+		var buf bytes.Buffer
+		z := NewZipStreamWriter(&buf, false)
+		Expect(z.WriteEntry(newZipEntry("a.txt", "bbbbbb"), io.MultiReader(strings.NewReader("bb"), ioutils.NewErrorReader(errors.New("read error"))))).To(MatchError("zip entry a.txt: read error"))
+		Expect(z.WriteEntry(newZipEntry("b.txt", "bbb"), strings.NewReader("bbb"))).To(Succeed())
+		Expect(z.Close()).To(Succeed())
+
+		Expect(readZip(buf.Bytes())).To(Equal(map[string]string{
+			"a.txt": "error: zip: checksum error",
+			"b.txt": "bbb",
+		}))
+	})
+
+	It("should reject invalid names", func() {
+		z := NewZipStreamWriter(io.Discard, false)
+		for _, name := range []string{"", "/abs", "a/../b", "a//b", `a\b`, "./a", "\xff"} {
+			Expect(z.WriteEntry(&ZipEntry{Name: name}, strings.NewReader(""))).To(Equal(ErrZipInvalidName), name)
+		}
+	})
+
+	It("should require sizes in store mode", func() {
+		z := NewZipStreamWriter(io.Discard, true)
+		Expect(z.WriteEntry(&ZipEntry{Name: "a", Size: -1}, strings.NewReader(""))).To(Equal(ErrZipEntrySizeUnknown))
+	})
+
+	It("should not write after close", func() {
+		z := NewZipStreamWriter(io.Discard, true)
+		Expect(z.Close()).To(Succeed())
+		Expect(z.WriteEntry(newZipEntry("a", ""), strings.NewReader(""))).To(Equal(ErrZipClosed))
+		Expect(z.Close()).To(Equal(ErrZipClosed))
+	})
+
+	It("should write zip64 records for many entries", func() {
+		entries := []*ZipEntry{}
+		var buf bytes.Buffer
+		z := NewZipStreamWriter(&buf, true)
+		for i := 0; i < 70000; i++ {
+			entry := newZipEntry(strconv.Itoa(i), "x")
+			entries = append(entries, entry)
+			Expect(z.WriteEntry(entry, strings.NewReader("x"))).To(Succeed())
+		}
+		Expect(z.Close()).To(Succeed())
+
+		size, err := ZipStoreSize(entries)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(size).To(Equal(int64(buf.Len())))
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zr.File).To(HaveLen(70000))
+		Expect(zr.File[69999].Name).To(Equal("69999"))
+	})
+
+	It("should write zip64 records for large entries", func() {
+		if testing.Short() {
+			Skip("writes 4 GiB")
+		}
+
+		const bigSize = 1<<32 + 10
+
+		entries := []*ZipEntry{
+			{Name: "big", Size: bigSize},
+			newZipEntry("small.txt", "small"),
+		}
+
+		buf := &sparseZipBuffer{}
+		z := NewZipStreamWriter(buf, true)
+		Expect(z.WriteEntry(entries[0], io.LimitReader(zeroReader{}, bigSize))).To(Succeed())
+		Expect(z.WriteEntry(entries[1], strings.NewReader("small"))).To(Succeed())
+		Expect(z.Close()).To(Succeed())
+
+		size, err := ZipStoreSize(entries)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(size).To(Equal(buf.size))
+
+		// archive/zip ignores the data descriptor sizes so check that the
+		// local header announces the zip64 data descriptor
+		header := make([]byte, 30+len("big")+20)
+		_, err = buf.ReadAt(header, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binary.LittleEndian.Uint16(header[4:])).To(Equal(uint16(45)))
+		Expect(binary.LittleEndian.Uint32(header[18:])).To(Equal(uint32(0xffffffff)))
+		Expect(binary.LittleEndian.Uint32(header[22:])).To(Equal(uint32(0xffffffff)))
+		Expect(binary.LittleEndian.Uint16(header[28:])).To(Equal(uint16(20)))
+		Expect(binary.LittleEndian.Uint16(header[33:])).To(Equal(uint16(0x0001)))
+		Expect(binary.LittleEndian.Uint16(header[35:])).To(Equal(uint16(16)))
+
+		// the small entry follows the 24 byte zip64 data descriptor
+		smallHeader := make([]byte, 30)
+		_, err = buf.ReadAt(smallHeader, int64(len(header))+bigSize+24)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binary.LittleEndian.Uint32(smallHeader)).To(Equal(uint32(0x04034b50)))
+		Expect(binary.LittleEndian.Uint16(smallHeader[28:])).To(Equal(uint16(0)))
+
+		zr, err := zip.NewReader(buf, buf.size)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(zr.File[0].UncompressedSize64).To(Equal(uint64(bigSize)))
+		rc, err := zr.File[1].Open()
+		Expect(err).NotTo(HaveOccurred())
+		data, err := ioutil.ReadAll(rc)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("small"))
+	})
+
+	It("should reject deflated entries larger than their declared size", func() {
+		if testing.Short() {
+			Skip("deflates 4 GiB")
+		}
+
+		const bigSize = 1<<32 + 10
+
+		buf := &sparseZipBuffer{}
+		z := NewZipStreamWriter(buf, false)
+		Expect(z.WriteEntry(&ZipEntry{Name: "big", Size: 10}, io.LimitReader(zeroReader{}, bigSize))).To(Equal(ErrZipEntryTooLarge))
+		Expect(z.WriteEntry(newZipEntry("small.txt", "small"), strings.NewReader("small"))).To(Equal(ErrZipEntryTooLarge))
+	})
+})
+
+var _ = Describe("ResponseZip", func() {
+	It("should write a store mode archive with Content-Length", func() {
+		entries := []*ZipEntry{
+			newZipEntry("a.txt", "aaa"),
+			{Name: "dir/"},
+			newZipEntry("dir/b.txt", "bbbb"),
+		}
+
+		w := httptest.NewRecorder()
+		Expect(ResponseZip(w, httptest.NewRequest("GET", "/", nil), "files.zip", entries, true)).To(Succeed())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/force-download"))
+		Expect(w.Header().Get("Content-Disposition")).To(Equal(`attachment; filename="files.zip"; filename*=UTF-8''files.zip`))
+		Expect(w.Header().Get("Content-Length")).To(Equal(strconv.Itoa(w.Body.Len())))
+
+		Expect(readZip(w.Body.Bytes())).To(Equal(map[string]string{
+			"a.txt":     "aaa",
+			"dir/":      "",
+			"dir/b.txt": "bbbb",
+		}))
+	})
+
+	It("should not set Content-Length in deflate mode", func() {
+		w := httptest.NewRecorder()
+		Expect(ResponseZip(w, httptest.NewRequest("GET", "/", nil), "files.zip", []*ZipEntry{newZipEntry("a.txt", "aaa")}, false)).To(Succeed())
+		Expect(w.Header().Get("Content-Length")).To(Equal(""))
+		Expect(readZip(w.Body.Bytes())).To(Equal(map[string]string{"a.txt": "aaa"}))
+	})
+
+	It("should not write the body for HEAD requests", func() {
+		w := httptest.NewRecorder()
+		Expect(ResponseZip(w, httptest.NewRequest("HEAD", "/", nil), "files.zip", []*ZipEntry{newZipEntry("a.txt", "aaa")}, true)).To(Succeed())
+		Expect(w.Header().Get("Content-Length")).NotTo(Equal(""))
+		Expect(w.Body.Len()).To(Equal(0))
+	})
+
+	It("should keep the Content-Length for entries which fail", func() {
+		failing := &ZipEntry{
+			Name: "fail.txt",
+			Size: 100,
+			Open: func() (io.ReadCloser, error) {
+				return nil, errors.New("not found")
+			},
+		}
+		entries := []*ZipEntry{newZipEntry("a.txt", "aaa"), failing, newZipEntry("b.txt", "bbb")}
+
+		w := httptest.NewRecorder()
+		err := ResponseZip(w, httptest.NewRequest("GET", "/", nil), "files.zip", entries, true)
+		Expect(err).To(MatchError("zip entry fail.txt: not found"))
+		var errZipEntries ErrZipEntries
+		Expect(errors.As(err, &errZipEntries)).To(BeTrue())
+		Expect(errZipEntries).To(HaveLen(1))
+
+		Expect(w.Header().Get("Content-Length")).To(Equal(strconv.Itoa(w.Body.Len())))
+		Expect(readZip(w.Body.Bytes())).To(Equal(map[string]string{
+			"a.txt":    "aaa",
+			"fail.txt": "error: zip: checksum error",
+			"b.txt":    "bbb",
+		}))
+	})
+
+	It("should reject entries without sizes in store mode", func() {
+		w := httptest.NewRecorder()
+		err := ResponseZip(w, httptest.NewRequest("GET", "/", nil), "files.zip", []*ZipEntry{{Name: "a", Size: -1}}, true)
+		Expect(err).To(Equal(ErrZipEntrySizeUnknown))
+		Expect(w.Header().Get("Content-Disposition")).To(Equal(""))
+	})
+})
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}